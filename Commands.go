@@ -0,0 +1,654 @@
+/*
+	Non-interactive subcommands so initchat can be driven from scripts
+ */
+
+package main
+
+import (
+	"./Messages"
+	"./initchat"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+//Exit codes of the subcommands
+const (
+	ExitOK = 0
+	ExitError = 1
+	ExitUsage = 2
+	ExitAuth = 3
+	ExitUnreachable = 4
+	ExitNotFound = 5
+)
+
+const commandUsage = `Usage: initchat [flags] [command]
+
+Without a command the interactive client starts. Commands:
+  send --group G [text]       send text to group G, read from stdin when no text is given
+  upload --group G path       upload a file to group G and print its file ID,
+                              encrypted when the group has E2E turned on
+  download [--group G] [--dir D] ID
+                              download a file and print the path it was saved to
+  groups                      list the groups you are in
+  invites                     list pending invites as number, group and sender
+  invites accept N            accept invite number N
+  invites decline N           decline invite number N
+  tail --group G [-n N]       print the last N messages of group G and follow new ones
+  users PREFIX                list users whose name starts with PREFIX
+  search [--user U] [--group G] [--since T] [--until T] query
+                              search the saved message history without connecting,
+                              needs the password to decrypt it
+  history --group G [-n N]    print the last N saved messages of group G without connecting
+  export --group G [--format F] path
+                              save the history of group G as json, markdown, html or mbox,
+                              picked from the extension unless --format is given, - for stdout
+  import [--group G] path     load a JSON export into the saved history
+  fingerprint                 print your E2E key fingerprint and the keys you know
+
+Every command takes --output text, json or ndjson. Listings print a JSON array for json
+and one object per line for ndjson, tail always prints one message object per line.
+
+Commands log in with a saved session, INITCHAT_USERNAME and INITCHAT_PASSWORD,
+or the client certificate given with -cert when no password is set.
+Exit codes: 0 ok, 1 error, 2 usage, 3 authentication, 4 server unreachable, 5 group or file not found
+
+Flags:
+`
+
+//Wrong arguments to a command, exits with ExitUsage
+type usageError struct {
+	message string
+}
+
+func (err *usageError) Error() string {
+	return err.message
+}
+
+var errNoCredentials = errors.New("no saved session, client certificate or username and password configured")
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"send": runSend,
+	"upload": runUpload,
+	"download": runDownload,
+	"groups": runGroups,
+	"invites": runInvites,
+	"tail": runTail,
+	"users": runUsers,
+}
+
+//Commands that only use local files and run without connecting
+var offlineCommands = map[string]command{
+	"search": runSearch,
+	"history": runHistory,
+	"export": runExport,
+	"import": runImport,
+	"fingerprint": runFingerprint,
+}
+
+//Runs the command named by args[0], which must be in commands or offlineCommands, and returns the process exit code
+func runCommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		cmd = offlineCommands[args[0]]
+	}
+	err := cmd(args[1:])
+	if err == flag.ErrHelp {
+		return ExitOK
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "initchat " + args[0] + ": " + err.Error())
+	}
+	return exitCode(err)
+}
+
+func exitCode(err error) int {
+	var usageErr *usageError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &usageErr):
+		return ExitUsage
+	case isUnreachable(err):
+		return ExitUnreachable
+	case errors.Is(err, initchat.ErrInvalidCredentials) || errors.Is(err, initchat.ErrCertificateRejected) || errors.Is(err, errNoCredentials) ||
+		errors.Is(err, errHistoryPassword):
+		return ExitAuth
+	case errors.Is(err, initchat.ErrNotMember) || errors.Is(err, initchat.ErrFileNotFound):
+		return ExitNotFound
+	default:
+		return ExitError
+	}
+}
+
+//Flag set for a command that reports errors instead of exiting
+func commandFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("initchat " + name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.StringVar(&outputFormat, "output", config.Output, "output format: text, json or ndjson")
+	return flags
+}
+
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !validOutput(outputFormat) {
+		return &usageError{"unknown output format \"" + outputFormat + "\", expected text, json or ndjson"}
+	}
+	return nil
+}
+
+//Resumes the saved session when it belongs to the configured user, otherwise logs in
+func commandLogin() error {
+	if saved := loadSavedSession(); saved != nil && (config.Username == "" || saved.Username == config.Username) {
+		ctx, cancel := requestContext()
+		err := client.Resume(ctx, saved.Username, saved.Token)
+		cancel()
+		if err == nil {
+			saveSession(client.SessionToken())
+			unlockConfigured(client.SessionToken().Username)
+			return nil
+		}
+		if isUnreachable(err) {
+			return err
+		}
+		forgetSession()
+	}
+	if (config.Username == "" || config.Password == "") && clientCert != nil {
+		ctx, cancel := requestContext()
+		err := client.LoginWithCertificate(ctx, config.Username)
+		cancel()
+		if err != nil {
+			return err
+		}
+		saveSession(client.SessionToken())
+		unlockConfigured(client.SessionToken().Username)
+		return nil
+	}
+	if config.Username == "" || config.Password == "" {
+		return errNoCredentials
+	}
+	ctx, cancel := requestContext()
+	err := client.Login(ctx, config.Username, config.Password)
+	cancel()
+	if err != nil {
+		return err
+	}
+	unlockHistory(config.Username, config.Password, true)
+	saveSession(client.SessionToken())
+	unlockKeyring(config.Username, config.Password)
+	return nil
+}
+
+//The configured password when it belongs to the user, which unlocks their E2E keys
+func configuredPassword(username string) string {
+	if username != config.Username {
+		return ""
+	}
+	return config.Password
+}
+
+//Opens history and E2E keys after a login that didn't check the password, using the configured one
+//when it is the user's. Neither is set aside or created when that password turns out to be wrong.
+func unlockConfigured(username string) {
+	password := configuredPassword(username)
+	if password != "" {
+		unlockHistory(username, password, false)
+	}
+	unlockKeyring(username, password)
+}
+
+//Logs in and opens the group
+func commandJoin(groupName string) (*Messages.GroupResp, error) {
+	if groupName == "" {
+		return nil, &usageError{"--group is required"}
+	}
+	if err := commandLogin(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+	return client.JoinGroup(ctx, groupName)
+}
+
+func runSend(args []string) error {
+	flags := commandFlags("send")
+	groupName := flags.String("group", "", "group to send to")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	text := strings.Join(flags.Args(), " ")
+	if text == "" {
+		input, readErr := ioutil.ReadAll(os.Stdin)
+		if readErr != nil {
+			return readErr
+		}
+		text = strings.TrimSpace(string(input))
+	}
+	if text == "" {
+		return &usageError{"nothing to send"}
+	}
+	group, err := commandJoin(*groupName)
+	if err != nil {
+		return err
+	}
+	//Earlier messages carry the keys the text has to be sealed for
+	openGroupMessages(*groupName, group.Messages)
+	sealed, err := outgoingText(*groupName, text)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+	return client.SendText(ctx, sealed)
+}
+
+func runUpload(args []string) error {
+	flags := commandFlags("upload")
+	groupName := flags.String("group", "", "group to upload to")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return &usageError{"expected one file path"}
+	}
+	group, err := commandJoin(*groupName)
+	if err != nil {
+		return err
+	}
+	//Earlier messages carry the keys an encrypted file's key has to be sealed for
+	openGroupMessages(*groupName, group.Messages)
+	ctx, cancel := transferContext()
+	defer cancel()
+	fileID, err := uploadFile(ctx, *groupName, flags.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	recordFile(*groupName, fileID, flags.Arg(0))
+	if outputFormat != OutputText {
+		return printJSON(fileJSON{FileID: fileID})
+	}
+	fmt.Println(fileID)
+	return nil
+}
+
+func runDownload(args []string) error {
+	flags := commandFlags("download")
+	groupName := flags.String("group", "", "group the file was sent to, if the server requires one")
+	dir := flags.String("dir", config.DownloadDir, "directory to save the file in")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return &usageError{"expected one file ID"}
+	}
+	if *groupName != "" {
+		group, err := commandJoin(*groupName)
+		if err != nil {
+			return err
+		}
+		//Picks up the key of an encrypted file shared since the last run
+		openGroupMessages(*groupName, group.Messages)
+	} else if err := commandLogin(); err != nil {
+		return err
+	}
+	ctx, cancel := transferContext()
+	defer cancel()
+	filePath, err := downloadFile(ctx, flags.Arg(0), *dir, nil)
+	if err != nil {
+		return err
+	}
+	if outputFormat != OutputText {
+		return printJSON(fileJSON{FileID: flags.Arg(0), Path: filePath})
+	}
+	fmt.Println(filePath)
+	return nil
+}
+
+func runGroups(args []string) error {
+	flags := commandFlags("groups")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := commandLogin(); err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	groupNames, err := client.Groups(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if outputFormat != OutputText {
+		return printList(groupsJSON(groupNames))
+	}
+	for _, groupName := range groupNames {
+		fmt.Println(groupName)
+	}
+	return nil
+}
+
+//Lists invites, or accepts or declines one by the number it was listed with
+func runInvites(args []string) error {
+	flags := commandFlags("invites")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	action := flags.Arg(0)
+	if action != "" && action != "accept" && action != "decline" {
+		return &usageError{"unknown invites action \"" + action + "\", expected accept or decline"}
+	}
+	inviteI := 0
+	if action != "" {
+		if flags.NArg() != 2 {
+			return &usageError{"expected an invite number"}
+		}
+		num, convErr := strconv.Atoi(flags.Arg(1))
+		if convErr != nil {
+			return &usageError{"invalid invite number \"" + flags.Arg(1) + "\""}
+		}
+		inviteI = num
+	}
+	if err := commandLogin(); err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+	invites, err := client.Invites(ctx)
+	if err != nil {
+		return err
+	}
+	if action == "" {
+		if outputFormat != OutputText {
+			return printList(invitesJSON(invites))
+		}
+		for i, invite := range invites {
+			fmt.Println(strconv.Itoa(i) + "\t" + invite.GroupName + "\t" + invite.FromUsername)
+		}
+		return nil
+	}
+	if inviteI < 0 || inviteI >= len(invites) {
+		return &usageError{"invite number out of range"}
+	}
+	if action == "accept" {
+		_, err = client.AcceptInvite(ctx, invites[inviteI].InviteID)
+	} else {
+		_, err = client.DeclineInvite(ctx, invites[inviteI].InviteID)
+	}
+	return err
+}
+
+//Prints recent messages then follows the group until interrupted
+func runTail(args []string) error {
+	flags := commandFlags("tail")
+	groupName := flags.String("group", "", "group to follow")
+	count := flags.Int("n", 10, "number of earlier messages to print")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	//Subscribe first so nothing sent while joining is missed
+	stream := client.TextMessages()
+	defer stream.Close()
+	group, err := commandJoin(*groupName)
+	if err != nil {
+		return err
+	}
+	earlier := groupHistory(*groupName, openGroupMessages(*groupName, group.Messages))
+	if *count >= 0 && len(earlier) > *count {
+		earlier = earlier[len(earlier) - *count:]
+	}
+	for _, textMsg := range earlier {
+		printStreamMessage(textMsg)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	for {
+		select {
+		case textMsg, ok := <-stream.Messages():
+			if !ok {
+				return nil
+			}
+			opened := openText(*groupName, textMsg, true)
+			if opened.Notice != "" {
+				fmt.Fprintln(os.Stderr, opened.Notice)
+			}
+			if opened.Message != nil {
+				recordMessage(*groupName, opened.Message)
+				printStreamMessage(opened.Message)
+			}
+		case <-interrupt:
+			return nil
+		}
+	}
+}
+
+//Messages are printed as they arrive, so json and ndjson both write one object per line
+func printStreamMessage(textMsg *Messages.TextMessage) {
+	if outputFormat != OutputText {
+		printJSON(textMessageJSON(textMsg))
+		return
+	}
+	for _, line := range renderer.Render(textMsg, "") {
+		fmt.Println(line)
+	}
+}
+
+func runUsers(args []string) error {
+	flags := commandFlags("users")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return &usageError{"expected a username prefix"}
+	}
+	if err := commandLogin(); err != nil {
+		return err
+	}
+	ctx, cancel := requestContext()
+	usernames, err := client.SearchUsers(ctx, flags.Arg(0))
+	cancel()
+	if err != nil {
+		return err
+	}
+	if outputFormat != OutputText {
+		return printList(usersJSON(usernames))
+	}
+	for _, username := range usernames {
+		fmt.Println(username)
+	}
+	return nil
+}
+
+func runSearch(args []string) error {
+	flags := commandFlags("search")
+	user := flags.String("user", "", "only messages from this user")
+	groupName := flags.String("group", "", "only messages in this group")
+	since := flags.String("since", "", "only messages sent at or after this time")
+	until := flags.String("until", "", "only messages sent at or before this time")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	input := strings.Join(flags.Args(), " ")
+	for filter, value := range map[string]string{"from": *user, "group": *groupName, "since": *since, "until": *until} {
+		if value != "" {
+			input += " " + filter + ":" + value
+		}
+	}
+	query, parseErr := parseSearch(input)
+	if parseErr != nil {
+		return &usageError{parseErr.Error()}
+	}
+	hist, err := offlineHistory()
+	if err != nil {
+		return err
+	}
+	hits, err := hist.Search(query)
+	if err != nil {
+		return err
+	}
+	if outputFormat != OutputText {
+		items := make([]interface{}, 0, len(hits))
+		for _, hit := range hits {
+			item := textMessageJSON(hit.Message)
+			item.Group = hit.Group
+			items = append(items, item)
+		}
+		return printList(items)
+	}
+	color := colorOutput()
+	for _, hit := range hits {
+		fmt.Println(formatSearchHit(hit, query.Terms, color))
+	}
+	return nil
+}
+
+//Opens the history with the configured credentials for commands that don't log in. The server never
+//checks the password here, so a wrong one fails instead of setting the history aside.
+func offlineHistory() (*History, error) {
+	if config.Username == "" || config.Password == "" {
+		return nil, errNoCredentials
+	}
+	hist, err := openHistory(config.Username, config.Password, false)
+	if err != nil {
+		return nil, err
+	}
+	if hist == nil {
+		return nil, errors.New("message history is disabled")
+	}
+	return hist, nil
+}
+
+func runHistory(args []string) error {
+	flags := commandFlags("history")
+	groupName := flags.String("group", "", "group to print")
+	count := flags.Int("n", 50, "number of messages to print, negative for all")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *groupName == "" {
+		return &usageError{"--group is required"}
+	}
+	hist, err := offlineHistory()
+	if err != nil {
+		return err
+	}
+	textMsgs, err := hist.Messages(*groupName)
+	if err != nil {
+		return err
+	}
+	if *count >= 0 && len(textMsgs) > *count {
+		textMsgs = textMsgs[len(textMsgs) - *count:]
+	}
+	if outputFormat == OutputJSON {
+		items := make([]interface{}, 0, len(textMsgs))
+		for _, textMsg := range textMsgs {
+			items = append(items, textMessageJSON(textMsg))
+		}
+		return printList(items)
+	}
+	for _, textMsg := range textMsgs {
+		printStreamMessage(textMsg)
+	}
+	return nil
+}
+
+func runExport(args []string) error {
+	flags := commandFlags("export")
+	groupName := flags.String("group", "", "group to export")
+	format := flags.String("format", "", "json, markdown, html or mbox")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *groupName == "" {
+		return &usageError{"--group is required"}
+	}
+	if flags.NArg() != 1 {
+		return &usageError{"expected one output path"}
+	}
+	if *format != "" && !validExportFormat(*format) {
+		return &usageError{"unknown export format \"" + *format + "\""}
+	}
+	hist, err := offlineHistory()
+	if err != nil {
+		return err
+	}
+	return exportGroup(hist, *groupName, flags.Arg(0), *format)
+}
+
+func runImport(args []string) error {
+	flags := commandFlags("import")
+	groupName := flags.String("group", "", "group to import into instead of the one the export names")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return &usageError{"expected one export path"}
+	}
+	hist, err := offlineHistory()
+	if err != nil {
+		return err
+	}
+	importedGroup, added, err := importGroup(hist, *groupName, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if outputFormat != OutputText {
+		return printJSON(map[string]interface{}{"group": importedGroup, "imported": added})
+	}
+	fmt.Fprintln(os.Stderr, importSummary(importedGroup, added))
+	return nil
+}
+
+func runFingerprint(args []string) error {
+	flags := commandFlags("fingerprint")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if config.Username == "" {
+		return errNoCredentials
+	}
+	kr, err := loadKeyring(config.Username, config.Password)
+	if err != nil {
+		return err
+	}
+	if kr == nil {
+		return errNoKeyring
+	}
+	if outputFormat != OutputText {
+		users := make(map[string]interface{}, len(kr.Users))
+		for username, known := range kr.Users {
+			user := map[string]interface{}{
+				"fingerprint": initchat.Fingerprint(known.PublicKey),
+				"verified": known.Verified,
+				"changed": len(known.PendingKey) > 0,
+			}
+			if len(known.PendingKey) > 0 {
+				user["pendingFingerprint"] = initchat.Fingerprint(known.PendingKey)
+			}
+			users[username] = user
+		}
+		return printJSON(map[string]interface{}{"fingerprint": kr.Fingerprint(), "users": users})
+	}
+	fmt.Println("Your fingerprint: " + kr.Fingerprint())
+	usernames := make([]string, 0, len(kr.Users))
+	for username := range kr.Users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		fmt.Println(knownKeyLine(username, kr.Users[username]))
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"./Messages"
+	"./initchat"
+	"./initchat/initchattest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//Connects the global client to a fresh mock server as alice, with sessions and keys kept in
+//temporary directories and history turned off
+func newCommandServer(t *testing.T) *initchattest.Server {
+	t.Helper()
+	server, err := initchattest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	config = defaultConfig()
+	config.Address = server.Addr
+	config.ProfileDir = t.TempDir()
+	config.HistoryDir = ""
+	config.Username = "alice"
+	config.Password = "secret"
+	renderer = newMessageRenderer(config)
+	ui = newLineUI(ioutil.Discard)
+	history = nil
+	keyring = nil
+	connected, err := initchat.Connect(func() (initchat.Transport, error) {
+		return initchat.Dial(server.URL, server.ClientTLS)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = connected
+	t.Cleanup(client.Close)
+	return server
+}
+
+func verifyServer(t *testing.T, server *initchattest.Server) {
+	t.Helper()
+	if err := server.Wait(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//initchat send exits right after the command returns, so the text must be written by then
+func TestSendThenClose(t *testing.T) {
+	server := newCommandServer(t)
+	server.Expect("login").Respond("auth", &Messages.AuthResp{Token: "token1"})
+	server.Expect("joinGroup").Respond("group", &Messages.GroupResp{})
+	server.Expect("textMsg").
+		WithBody(&Messages.TextMessageReq{Message: "hello"}).
+		WithoutRequestID()
+
+	if code := runCommand([]string{"send", "--group", "team", "hello"}); code != ExitOK {
+		t.Fatalf("send exited with %d", code)
+	}
+	client.Close()
+	verifyServer(t, server)
+}
+
+//A server that stops answering mid-transfer must not hold the command past TransferTimeout
+func TestUploadDeadline(t *testing.T) {
+	server := newCommandServer(t)
+	server.Expect("login").Respond("auth", &Messages.AuthResp{Token: "token1"})
+	server.Expect("joinGroup").Respond("group", &Messages.GroupResp{})
+	server.Expect("uploadStart")
+	defer func(timeout time.Duration) {
+		TransferTimeout = timeout
+	}(TransferTimeout)
+	TransferTimeout = 200 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := ioutil.WriteFile(path, []byte("notes"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if code := runCommand([]string{"upload", "--group", "team", path}); code != ExitUnreachable {
+		t.Errorf("upload to a stalled server exited with %d, want %d", code, ExitUnreachable)
+	}
+	if elapsed := time.Since(start); elapsed > 5 * time.Second {
+		t.Errorf("upload gave up after %v", elapsed)
+	}
+	verifyServer(t, server)
+}
+
+//Offline commands open the history with a password the server never checked, a wrong one must fail
+//instead of answering from an empty history
+func TestOfflineWrongPassword(t *testing.T) {
+	useHistoryDir(t)
+	config.Username = "alice"
+	hist := testOpenHistory(t, "alice", "secret")
+	if err := hist.Record("team", &Messages.TextMessage{Username: "bob", Message: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	exportPath := filepath.Join(t.TempDir(), "team.json")
+
+	config.Password = "typo"
+	for _, args := range [][]string{
+		{"search", "hello"},
+		{"history", "--group", "team"},
+		{"export", "--group", "team", exportPath},
+	} {
+		if code := runCommand(args); code != ExitAuth {
+			t.Errorf("%s with a wrong password exited with %d, want %d", args[0], code, ExitAuth)
+		}
+	}
+	if _, err := os.Stat(exportPath); !os.IsNotExist(err) {
+		t.Error("export with a wrong password wrote a file")
+	}
+
+	config.Password = "secret"
+	stored, err := testOpenHistory(t, "alice", "secret").Messages("team")
+	if err != nil || len(stored) != 1 {
+		t.Errorf("history after the wrong password = %v, %v, want the recorded message", messageTexts(stored), err)
+	}
+}
+
+//tail and send usually resume the saved session, they must keep recording history when the password is configured
+func TestResumeOpensHistory(t *testing.T) {
+	server := newCommandServer(t)
+	config.HistoryDir = t.TempDir()
+	testOpenHistory(t, "alice", "secret")
+	if err := saveSession(initchat.SessionToken{Username: "alice", Token: "token1"}); err != nil {
+		t.Fatal(err)
+	}
+	server.Expect("resume").
+		WithBody(&Messages.ResumeReq{Token: "token1"}).
+		Respond("auth", &Messages.AuthResp{Token: "token1"})
+
+	if err := commandLogin(); err != nil {
+		t.Fatal(err)
+	}
+	if history == nil {
+		t.Fatal("resumed session left the history closed")
+	}
+	verifyServer(t, server)
+
+	history = nil
+	config.Password = "typo"
+	server.Expect("resume").Respond("auth", &Messages.AuthResp{Token: "token1"})
+	if err := commandLogin(); err != nil {
+		t.Fatal(err)
+	}
+	if history != nil {
+		t.Error("a wrong configured password opened the history")
+	}
+	if stored, err := openHistory("alice", "secret", false); err != nil || stored == nil {
+		t.Errorf("wrong configured password damaged the history: %v", err)
+	}
+	verifyServer(t, server)
+}
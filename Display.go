@@ -6,46 +6,84 @@ package main
 
 import (
 	"./Messages"
-	"bufio"
+	"./initchat"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
-	"runtime"
 	"strconv"
 	"strings"
 	"time"
 )
 
-var Reader = bufio.NewReader(os.Stdin)
+//How long the display waits for any single request before giving up
+var RequestTimeout = 10 * time.Second
+
+//How long a whole upload or download may take, however steadily chunks arrive
+var TransferTimeout = 30 * time.Minute
 
 func readString(prompts ...interface{}) string {
 	for _, elm := range prompts {
-		fmt.Println(elm)
+		ui.Println(elm)
+	}
+	text, err := ui.ReadLine()
+	//Input closed or interrupted, nothing more can be read
+	if err != nil {
+		ui.Close()
+		os.Exit(0)
 	}
-	text, _ := Reader.ReadString('\n')
 	return strings.TrimSpace(text)
 }
 
-//Clears entire terminal
-func clearScreen() {
-	if runtime.GOOS == "linux" {
-		cmd := exec.Command("clear") //Command to clear linux terminal
-		cmd.Stdout = os.Stdout
-		cmd.Run()
-	} else if runtime.GOOS == "windows" {
-		cmd := exec.Command("cmd", "/c", "cls") //Clears windows command
-		cmd.Stdout = os.Stdout
-		cmd.Run()
-	} else {
-		println("Could not clear screen")
+func requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), RequestTimeout)
+}
+
+//Context for a whole file transfer, cancelled after TransferTimeout or when the user presses Ctrl-C
+func transferContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), TransferTimeout)
+	stopInterrupt := ui.OnInterrupt(cancel)
+	return ctx, func() {
+		stopInterrupt()
+		cancel()
+	}
+}
+
+//Timeouts and disconnects mean the server can't be reached, so menus go back instead of retrying
+func isUnreachable(err error) bool {
+	_, isTimeout := err.(*initchat.TimeoutError)
+	return isTimeout || err == initchat.ErrDisconnected
+}
+
+//Shows connection changes so the user knows when input is not reaching the server
+func showConnectionStates(states <-chan initchat.ConnState) {
+	for state := range states {
+		switch state {
+		case initchat.Disconnected:
+			ui.SetStatus("Connection lost")
+		case initchat.Reconnecting:
+			ui.SetStatus("Reconnecting...")
+		case initchat.Connected:
+			ui.SetStatus("Reconnected to " + config.Address)
+			//Resuming may have issued a new token
+			saveSession(client.SessionToken())
+		}
 	}
 }
 
 func readAuthSelection() {
-	clearScreen()
+	ui.Clear()
 	for {
-		selection := readString("1) Sign Up", "2) Login", "3) Exit")
+		saved := loadSavedSession()
+		prompts := []interface{}{"1) Sign Up", "2) Login", "3) Exit"}
+		if saved != nil {
+			prompts = append(prompts, "4) Resume session as " + saved.Username)
+		}
+		if clientCert != nil {
+			prompts = append(prompts, "5) Login with certificate for " + certificateName())
+		}
+		selection := readString(prompts...)
 		switch selection {
 		case "1":
 			readSignUp()
@@ -53,61 +91,131 @@ func readAuthSelection() {
 			readLogin()
 		case "3":
 			return
+		case "4":
+			if saved == nil {
+				ui.Println("Invalid Input")
+				continue
+			}
+			readResume(*saved)
+		case "5":
+			if clientCert == nil {
+				ui.Println("Invalid Input")
+				continue
+			}
+			readCertLogin()
 		default:
-			fmt.Println("Invalid Input")
+			ui.Println("Invalid Input")
 		}
 	}
 }
 
+func readResume(saved initchat.SessionToken) {
+	ctx, cancel := requestContext()
+	err := client.Resume(ctx, saved.Username, saved.Token)
+	cancel()
+	if err != nil {
+		if !isUnreachable(err) {
+			forgetSession()
+		}
+		ui.Println("Could not resume session: ", err)
+		return
+	}
+	unlockKeyring(saved.Username, "")
+	showLockedNotice()
+	readHome()
+}
+
+//Without the password history and E2E keys can't be unlocked
+func showLockedNotice() {
+	if config.HistoryDir != "" {
+		ui.Println("Message history stays locked until you log in with your password")
+	}
+	if keyring != nil && keyring.locked() {
+		ui.Println("E2E keys stay locked until you log in with your password")
+	}
+}
+
+//Logs in as the user of the client certificate, config.Username picks one if the certificate maps to several
+func readCertLogin() {
+	ctx, cancel := requestContext()
+	err := client.LoginWithCertificate(ctx, config.Username)
+	cancel()
+	if err != nil {
+		ui.Println("Certificate Login Failed: ", err)
+		return
+	}
+	unlockKeyring(client.SessionToken().Username, "")
+	showLockedNotice()
+	readHome()
+}
+
 func readSignUp() {
-	clearScreen()
-	fmt.Println("Enter ~cancel to leave")
+	ui.Clear()
+	ui.Println("Enter ~cancel to leave")
 	for {
 		username := readString("Enter Username: ")
 		if username == "~cancel" {
-			clearScreen()
+			ui.Clear()
 			return
 		}
 		password := readString("Enter Password: ")
 		if password == "~cancel" {
-			clearScreen()
+			ui.Clear()
 			return
 		}
-		err := signUp(username, password)
+		ctx, cancel := requestContext()
+		err := client.SignUp(ctx, username, password)
+		cancel()
 		if err == nil {
+			unlockHistory(username, password, true)
+			unlockKeyring(username, password)
 			readHome()
 			return
 		}
-		fmt.Println("SignUp Failed")
+		if isUnreachable(err) {
+			ui.Println(err)
+			return
+		}
+		ui.Println("SignUp Failed: ", err)
 	}
 }
 
 func readLogin() {
-	clearScreen()
-	fmt.Println("Enter ~cancel to leave")
+	ui.Clear()
+	ui.Println("Enter ~cancel to leave")
 	for {
 		username := readString("Enter Username: ")
 		if username == "~cancel" {
-			clearScreen()
+			ui.Clear()
 			return
 		}
 		password := readString("Enter Password: ")
 		if password == "~cancel" {
-			clearScreen()
+			ui.Clear()
 			return
 		}
-		err := login(username, password)
+		ctx, cancel := requestContext()
+		err := client.Login(ctx, username, password)
+		cancel()
 		//If login successful, show home display
 		if err == nil {
+			unlockHistory(username, password, true)
+			unlockKeyring(username, password)
 			readHome()
 			return
 		}
-		fmt.Println("Login Failed")
+		if isUnreachable(err) {
+			ui.Println(err)
+			return
+		}
+		ui.Println("Login Failed: ", err)
 	}
 }
 
 func readHome() {
-	clearScreen()
+	ui.Clear()
+	stopSessionWatch := startSessionWatch()
+	stopInviteWatch := startInviteWatch()
 	for {
 		selection := readString(
 			"1) Create Chat Group",
@@ -123,41 +231,111 @@ func readHome() {
 		case "3":
 			readInvites()
 		case "4":
-			clearScreen()
+			stopSessionWatch()
+			stopInviteWatch()
+			forgetSession()
+			history = nil
+			keyring = nil
+			client.SignOut()
+			ui.Clear()
 			return
 		default:
-			fmt.Println("Invalid Input")
+			ui.Println("Invalid Input")
 		}
 	}
 }
 
 func readCreateGroup() {
-	clearScreen()
-	fmt.Println("Enter ~cancel to go back")
+	ui.Clear()
+	ui.Println("Enter ~cancel to go back")
 	for {
 		groupName := readString("Enter Group Name: ")
 		if groupName == "~cancel" {
-
+			ui.Clear()
+			return
 		}
-		group, err := createGroup(groupName)
+		ctx, cancel := requestContext()
+		group, err := client.CreateGroup(ctx, groupName)
+		cancel()
 		if err == nil {
-			readGroup(*group)
+			readGroup(groupName, *group)
+			return
+		}
+		if isUnreachable(err) {
+			ui.Println(err)
 			return
 		}
-		fmt.Println("Create Group Failed!")
+		ui.Println("Create Group Failed: ", err)
+	}
+}
+
+//Updates a single progress display for a file transfer
+func showProgress(action string) initchat.ProgressFunc {
+	return func(done uint64, total uint64) {
+		percent := uint64(100)
+		if total > 0 {
+			percent = done * 100 / total
+		}
+		ui.Progress(fmt.Sprintf("%s: %3d%% (%d / %d KB)", action, percent, done / 1024, total / 1024))
+	}
+}
+
+//Status tells how the message was protected, such as e2e, and is left out when empty
+func printTextMessage(textMsg *Messages.TextMessage, status string) {
+	for _, line := range renderer.Render(textMsg, status) {
+		ui.Println(line + "\n")
+	}
+}
+
+func printMessages(stream *initchat.TextStream, groupName string) {
+	for textMsg := range stream.Messages() {
+		opened := openText(groupName, textMsg, true)
+		if opened.Notice != "" {
+			ui.Println("*** " + opened.Notice)
+		}
+		if opened.Message != nil {
+			recordMessage(groupName, opened.Message)
+			printTextMessage(opened.Message, opened.Status)
+			notifyMessage(groupName, opened.Message.Username, messageText(opened.Message))
+		}
+	}
+}
+
+//Decrypts the group's earlier messages and learns the keys announced among them
+func openGroupMessages(groupName string, textMsgs []*Messages.TextMessage) []*Messages.TextMessage {
+	var opened []*Messages.TextMessage
+	for _, textMsg := range textMsgs {
+		if openedMsg := openText(groupName, textMsg, false); openedMsg.Message != nil {
+			opened = append(opened, openedMsg.Message)
+		} else if openedMsg.Notice != "" {
+			ui.Println("*** " + openedMsg.Notice)
+		}
 	}
+	return opened
 }
 
-func readGroup(groupMsg Messages.GroupResp) {
-	clearScreen()
-	msgChannel := listenForMessages()
-	fmt.Println("Commands:\n~invite\t#Invite a user\n" +
+func readGroup(groupName string, groupMsg Messages.GroupResp) {
+	ui.Clear()
+	renderer.Reset()
+	stream := client.TextMessages()
+	go printMessages(stream, groupName)
+	ui.Println("Commands:\n~invite\t#Invite a user\n" +
 		"~leave\t#Leave the group\n" +
-		"~upload {path}\t#Send file\n" +
-		"~download {fileID}\t#Download file")
-	for _, textMsg := range groupMsg.Messages {
-		t := time.Unix(int64(textMsg.Time), 0)
-		fmt.Println("[" + t.Format("3:04PM") + "] " + textMsg.Username + " >> ", textMsg.Message + "\n")
+		"~upload {path}\t#Send file, encrypted when E2E is on\n" +
+		"~download {fileID}\t#Download file\n" +
+		"~search {query}\t#Search saved messages, filter with from:USER group:GROUP since:TIME until:TIME\n" +
+		"~export {path} [format]\t#Save the group's history as json, markdown, html or mbox\n" +
+		"~import {path}\t#Load a JSON export into the saved history\n" +
+		"~e2e [on|off]\t#Show or change end-to-end encryption for this group\n" +
+		"~fingerprint\t#Show your key fingerprint and the keys of members\n" +
+		"~verify {user} {fingerprint}\t#Mark a member's key verified after comparing fingerprints\n" +
+		"~notify [all|mentions|off]\t#Show or change which messages in this group notify you")
+	for _, textMsg := range groupHistory(groupName, openGroupMessages(groupName, groupMsg.Messages)) {
+		printTextMessage(textMsg, "")
+	}
+	if keyring != nil && keyring.isEncrypted(groupName) {
+		ui.Println("*** Messages and files in this group are end-to-end encrypted")
+		announceKey()
 	}
 
 	for {
@@ -165,39 +343,233 @@ func readGroup(groupMsg Messages.GroupResp) {
 		if len(input) > 0 {
 			if input[0] == '~' {
 				if input == "~invite" {
-					close(msgChannel)
-					readInvite()
+					stream.Close()
+					readInvite(groupName)
 				} else if input == "~leave" {
-					leaveGroup()
-					close(msgChannel)
-					clearScreen()
+					ctx, cancel := requestContext()
+					if leaveErr := client.LeaveGroup(ctx); leaveErr != nil {
+						ui.Println(leaveErr)
+					}
+					cancel()
+					stream.Close()
+					ui.Clear()
 					return
 				} else if strings.Index(input, "~upload") == 0 {
 					pathStr := input[len("~upload"):]
 					pathStr = strings.TrimSpace(pathStr)
-					uploadFile(pathStr)
+					ui.Println("Press Ctrl-C to cancel")
+					ctx, cancel := transferContext()
+					fileID, uploadErr := uploadFile(ctx, groupName, pathStr, showProgress("Uploading"))
+					cancel()
+					ui.Progress("")
+					if errors.Is(uploadErr, context.Canceled) {
+						ui.Println("Upload Cancelled, run ~upload again to resume")
+					} else if uploadErr != nil {
+						ui.Println("Upload Failed: ", uploadErr)
+						if errors.Is(uploadErr, initchat.ErrCorruptFile) {
+							ui.Println("The server received a corrupted copy and discarded it")
+						} else if isUnreachable(uploadErr) {
+							ui.Println("Run ~upload again once reconnected to resume")
+						}
+					} else {
+						ui.Println("Upload Successful! File ID: " + fileID)
+						recordFile(groupName, fileID, pathStr)
+					}
 				} else if strings.Index(input, "~download") == 0 {
 					fileID := input[len("~download"):]
 					fileID = strings.TrimSpace(fileID)
-					downloadErr := downloadFile(fileID)
-					if downloadErr != nil {
-						fmt.Println(downloadErr)
+					ui.Println("Press Ctrl-C to cancel")
+					ctx, cancel := transferContext()
+					filePath, downloadErr := downloadFile(ctx, fileID, config.DownloadDir, showProgress("Downloading"))
+					cancel()
+					ui.Progress("")
+					if errors.Is(downloadErr, context.Canceled) {
+						ui.Println("Download Cancelled, run ~download again to resume")
+					} else if downloadErr != nil {
+						ui.Println("Download Failed: ", downloadErr)
+						if errors.Is(downloadErr, initchat.ErrCorruptFile) {
+							ui.Println("The file was discarded, run ~download again to fetch it from the start")
+						} else if isUnreachable(downloadErr) {
+							ui.Println("Run ~download again once reconnected to resume")
+						}
 					} else {
-						fmt.Println("Download Successful!")
+						ui.Println("Download Successful! Saved to " + filePath)
 					}
+				} else if strings.Index(input, "~search") == 0 {
+					showSearch(groupName, strings.TrimSpace(input[len("~search"):]))
+				} else if strings.Index(input, "~export") == 0 {
+					showExport(groupName, strings.Fields(input[len("~export"):]))
+				} else if strings.Index(input, "~import") == 0 {
+					showImport(strings.TrimSpace(input[len("~import"):]))
+				} else if strings.Index(input, "~e2e") == 0 {
+					readE2E(groupName, strings.TrimSpace(input[len("~e2e"):]))
+				} else if input == "~fingerprint" {
+					showFingerprints(groupName)
+				} else if strings.Index(input, "~notify") == 0 {
+					readNotify(groupName, strings.TrimSpace(input[len("~notify"):]))
+				} else if strings.Index(input, "~verify") == 0 {
+					readVerify(strings.Fields(input[len("~verify"):]))
 				} else {
-					fmt.Println("Invalid Command")
+					ui.Println("Invalid Command")
 				}
 			} else {
-				sendTextMessage(input)
+				text, sealErr := outgoingText(groupName, input)
+				if sealErr != nil {
+					ui.Println("Message Not Sent: ", sealErr)
+					continue
+				}
+				ctx, cancel := requestContext()
+				if sendErr := client.SendText(ctx, text); sendErr != nil {
+					ui.Println(sendErr)
+				}
+				cancel()
 			}
 		}
 	}
 }
 
-func readInvite() {
-	clearScreen()
-	fmt.Println("Search for user or enter command: \n" +
+//Searches the stored history, only the open group unless the query names another
+func showSearch(groupName string, input string) {
+	if history == nil {
+		ui.Println("Search needs the message history, log in with your password to unlock it")
+		return
+	}
+	query, parseErr := parseSearch(input)
+	if parseErr != nil {
+		ui.Println("Search Failed: ", parseErr)
+		return
+	}
+	if query.Group == "" {
+		query.Group = groupName
+	}
+	hits, err := history.Search(query)
+	if err != nil {
+		ui.Println("Search Failed: ", err)
+	}
+	color := colorOutput()
+	for _, hit := range hits {
+		ui.Println(formatSearchHit(hit, query.Terms, color))
+	}
+	ui.Println(strconv.Itoa(len(hits)) + " matching messages")
+}
+
+func readE2E(groupName string, setting string) {
+	if keyring == nil {
+		ui.Println("E2E keys are not available")
+		return
+	}
+	switch setting {
+	case "":
+	case "on", "off":
+		if err := keyring.setEncrypted(groupName, setting == "on"); err != nil {
+			ui.Println("Could not save E2E setting: ", err)
+			return
+		}
+		if setting == "on" {
+			announceKey()
+		}
+	default:
+		ui.Println("Usage: ~e2e [on|off]")
+		return
+	}
+	if keyring.isEncrypted(groupName) {
+		ui.Println("End-to-end encryption is on, " + strconv.Itoa(len(keyring.recipients(groupName)) - 1) + " members can read your messages")
+	} else {
+		ui.Println("End-to-end encryption is off")
+	}
+}
+
+func readNotify(groupName string, level string) {
+	if level != "" {
+		if !validNotifyLevel(level) {
+			ui.Println("Usage: ~notify [all|mentions|off]")
+			return
+		}
+		if err := setGroupNotifyLevel(groupName, level); err != nil {
+			ui.Println("Could not save notification setting: ", err)
+		}
+	}
+	switch groupNotifyLevel(groupName) {
+	case NotifyAll:
+		ui.Println("Every message in this group notifies you")
+	case NotifyMentions:
+		ui.Println("Messages in this group notify you when they mention you")
+	default:
+		ui.Println("Messages in this group don't notify you")
+	}
+}
+
+func showFingerprints(groupName string) {
+	if keyring == nil {
+		ui.Println("E2E keys are not available")
+		return
+	}
+	ui.Println("Your fingerprint: " + keyring.Fingerprint())
+	members := keyring.memberKeys(groupName)
+	if len(members) == 0 {
+		ui.Println("No members have published keys in this group")
+	}
+	for _, member := range members {
+		ui.Println(member)
+	}
+}
+
+func readVerify(args []string) {
+	if keyring == nil {
+		ui.Println("E2E keys are not available")
+		return
+	}
+	if len(args) < 2 {
+		ui.Println("Usage: ~verify {user} {fingerprint}")
+		return
+	}
+	if err := keyring.verify(args[0], strings.Join(args[1:], "")); err != nil {
+		ui.Println("Verify Failed: ", err)
+		return
+	}
+	ui.Println(args[0] + "'s key is verified")
+}
+
+func showExport(groupName string, args []string) {
+	if history == nil {
+		ui.Println("Export needs the message history, log in with your password to unlock it")
+		return
+	}
+	if len(args) == 0 || len(args) > 2 {
+		ui.Println("Usage: ~export {path} [json|markdown|html|mbox]")
+		return
+	}
+	format := ""
+	if len(args) == 2 {
+		format = args[1]
+	}
+	if err := exportGroup(history, groupName, args[0], format); err != nil {
+		ui.Println("Export Failed: ", err)
+		return
+	}
+	ui.Println("Exported to " + args[0])
+}
+
+func showImport(path string) {
+	if history == nil {
+		ui.Println("Import needs the message history, log in with your password to unlock it")
+		return
+	}
+	if path == "" {
+		ui.Println("Usage: ~import {path}")
+		return
+	}
+	groupName, added, err := importGroup(history, "", path)
+	if err != nil {
+		ui.Println("Import Failed: ", err)
+		return
+	}
+	ui.Println(importSummary(groupName, added))
+}
+
+func readInvite(groupName string) {
+	ui.Clear()
+	ui.Println("Search for user or enter command: \n" +
 		"~cancel\t#Cancel Search\n" +
 		"~invite <User #>\t#Invite user with number to group")
 	var usernames []string
@@ -206,12 +578,14 @@ func readInvite() {
 		if len(input) > 0 {
 			if input[0] == '~' {
 				if input == "~cancel" {
-					group, err := refreshGroup()
+					ctx, cancel := requestContext()
+					group, err := client.RefreshGroup(ctx)
+					cancel()
 					if err == nil {
-						readGroup(*group)
+						readGroup(groupName, *group)
 						return
 					}
-					fmt.Println("RefreshGroup Error")
+					ui.Println("RefreshGroup Error: ", err)
 				} else if strings.Index(input, "~invite") == 0 {
 					userNumStr := input[len("~invite"):]
 					userNumStr = strings.TrimSpace(userNumStr)
@@ -219,33 +593,43 @@ func readInvite() {
 					if convErr == nil {
 						if userI >= 0 && userI < len(usernames) {
 							var username = usernames[userI]
-							inviteUser(username)
-							group, err := refreshGroup()
+							ctx, cancel := requestContext()
+							if inviteErr := client.Invite(ctx, username); inviteErr != nil {
+								ui.Println(inviteErr)
+							}
+							group, err := client.RefreshGroup(ctx)
+							cancel()
 							if err == nil {
-								readGroup(*group)
+								readGroup(groupName, *group)
 								return
 							}
-							fmt.Println("RefreshGroup Error")
+							ui.Println("RefreshGroup Error: ", err)
 						}
 					} else {
-						fmt.Println("Invalid User#")
+						ui.Println("Invalid User#")
 					}
 				} else {
-					fmt.Println("Invalid Command")
+					ui.Println("Invalid Command")
 				}
 			} else {
-				recvUsernames, err := searchUsers(input)
+				ctx, cancel := requestContext()
+				recvUsernames, err := client.SearchUsers(ctx, input)
+				cancel()
 				if err != nil {
-					fmt.Println("Search User ERROR")
+					if isUnreachable(err) {
+						ui.Println(err)
+						continue
+					}
+					ui.Println("Search User ERROR: ", err)
 				}
 				usernames = recvUsernames
 				if len(usernames) > 0 {
-					fmt.Println("FOUND USERS: ")
+					ui.Println("FOUND USERS: ")
 					for i, username := range usernames {
-						fmt.Println(i, ":", username)
+						ui.Println(i, ":", username)
 					}
 				} else {
-					fmt.Println("No matching users found")
+					ui.Println("No matching users found")
 				}
 			}
 		}
@@ -253,48 +637,55 @@ func readInvite() {
 }
 
 func readGroupList() {
-	clearScreen()
+	ui.Clear()
 
-	groupNames, err := getGroupList()
+	ctx, cancel := requestContext()
+	groupNames, err := client.Groups(ctx)
+	cancel()
 	if err != nil {
-		fmt.Println("Failed to get groups")
+		ui.Println("Failed to get groups: ", err)
 		return
 	}
 	if len(groupNames) == 0 {
-		fmt.Println("You Aren't In Any Groups...")
+		ui.Println("You Aren't In Any Groups...")
 		return
 	}
-	fmt.Println("Type ~cancel to leave")
+	ui.Println("Type ~cancel to leave")
 	for i, groupName := range groupNames {
-		fmt.Println(i, ": " + groupName)
+		ui.Println(i, ": " + groupName)
 	}
 	for {
 		input := readString("Enter Group # to Join: ")
 		if len(input) > 0 {
 			if input[0] == '~' {
 				if input == "~cancel" {
-					clearScreen()
+					ui.Clear()
 					return
 				} else {
-					fmt.Println("Invalid command")
+					ui.Println("Invalid command")
 				}
 			} else {
 				groupNum, convErr := strconv.Atoi(input)
 				if convErr == nil {
 					if groupNum >= 0 && groupNum < len(groupNames) {
 						groupName := groupNames[groupNum]
-						group, err := joinGroup(groupName)
+						ctx, cancel := requestContext()
+						group, err := client.JoinGroup(ctx, groupName)
+						cancel()
 						if err == nil {
-							readGroup(*group)
+							readGroup(groupName, *group)
+							return
+						} else if isUnreachable(err) {
+							ui.Println(err)
 							return
 						} else {
-							fmt.Println("Join group failed")
+							ui.Println("Join group failed: ", err)
 						}
 					} else {
-						fmt.Println("Group# out of range")
+						ui.Println("Group# out of range")
 					}
 				} else {
-					fmt.Println("Invalid integer")
+					ui.Println("Invalid integer")
 				}
 			}
 		}
@@ -304,21 +695,23 @@ func readGroupList() {
 func printInvites(invites []*Messages.InvitesResp_Invite) {
 	if len(invites) > 0 {
 		for i, invite := range invites {
-			fmt.Println(i, ": ", invite.GroupName + " from " + invite.FromUsername)
+			ui.Println(i, ": ", invite.GroupName + " from " + invite.FromUsername)
 		}
 	} else {
-		fmt.Println("No Invites...")
+		ui.Println("No Invites...")
 	}
 }
 
 func readInvites() {
-	clearScreen()
-	invites, err := getInvites()
+	ui.Clear()
+	ctx, cancel := requestContext()
+	invites, err := client.Invites(ctx)
+	cancel()
 	if err != nil {
-		fmt.Println("Could not get invites")
+		ui.Println("Could not get invites: ", err)
 		return
 	}
-	fmt.Println("Type ~cancel to leave\n" +
+	ui.Println("Type ~cancel to leave\n" +
 		"~accept <invite #>\t#Accept invite\n" +
 		"~decline <invite #>\t#Decline invite\n" +
 		"~refresh\t#Refresh invites")
@@ -327,12 +720,14 @@ func readInvites() {
 		input := readString("Enter command: ")
 		if len(input) > 0 {
 			if input == "~cancel" {
-				clearScreen()
+				ui.Clear()
 				return
 			} else if input == "~refresh" {
-				recvInvites, err := getInvites()
+				ctx, cancel := requestContext()
+				recvInvites, err := client.Invites(ctx)
+				cancel()
 				if err != nil {
-					fmt.Println("Could not get invites")
+					ui.Println("Could not get invites: ", err)
 					return
 				}
 				invites = recvInvites
@@ -344,12 +739,17 @@ func readInvites() {
 				if convErr == nil {
 					if inviteI >= 0 && inviteI < len(invites) {
 						invite := invites[inviteI]
-						recvInvites, err := acceptInvite(invite.InviteID)
+						ctx, cancel := requestContext()
+						recvInvites, err := client.AcceptInvite(ctx, invite.InviteID)
+						cancel()
 						if err == nil {
 							invites = recvInvites
 							printInvites(invites)
+						} else if isUnreachable(err) {
+							ui.Println(err)
+							return
 						} else {
-							log.Println("Could not accept invite")
+							ui.Println("Could not accept invite: ", err)
 						}
 					} else {
 						log.Println("Invite # out of range")
@@ -364,12 +764,17 @@ func readInvites() {
 				if convErr == nil {
 					if inviteI >= 0 && inviteI < len(invites) {
 						invite := invites[inviteI]
-						recvInvites, err := declineInvite(invite.InviteID)
+						ctx, cancel := requestContext()
+						recvInvites, err := client.DeclineInvite(ctx, invite.InviteID)
+						cancel()
 						if err == nil {
 							invites = recvInvites
 							printInvites(invites)
+						} else if isUnreachable(err) {
+							ui.Println(err)
+							return
 						} else {
-							log.Println("Could not decline invite")
+							ui.Println("Could not decline invite: ", err)
 						}
 					} else {
 						log.Println("Invite # out of range")
@@ -378,7 +783,7 @@ func readInvites() {
 					log.Println("Could not convert invite #")
 				}
 			} else {
-				fmt.Println("Invalid Command")
+				ui.Println("Invalid Command")
 			}
 		}
 	}
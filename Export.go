@@ -0,0 +1,265 @@
+/*
+	Writes a group's stored history to JSON, Markdown, HTML or mbox and loads JSON exports back
+ */
+
+package main
+
+import (
+	"./Messages"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var exportFormats = []string{"json", "markdown", "html", "mbox"}
+
+//What a JSON export contains, also what import reads back
+type Export struct {
+	Group string `json:"group"`
+	Exported string `json:"exported"`
+	Messages []messageJSON `json:"messages"`
+	Files []FileRef `json:"files"`
+}
+
+//Picks the format from the file extension, JSON when it isn't recognized
+func exportFormatFor(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown":
+		return "markdown"
+	case ".html", ".htm":
+		return "html"
+	case ".mbox", ".txt":
+		return "mbox"
+	}
+	return "json"
+}
+
+func validExportFormat(format string) bool {
+	for _, known := range exportFormats {
+		if format == known {
+			return true
+		}
+	}
+	return false
+}
+
+//Collects the group's stored messages and file references
+func buildExport(hist *History, groupName string) (*Export, error) {
+	textMsgs, err := hist.Messages(groupName)
+	if err != nil {
+		return nil, err
+	}
+	files, err := hist.Files(groupName)
+	if err != nil {
+		return nil, err
+	}
+	export := &Export{
+		Group: groupName,
+		Exported: time.Now().UTC().Format(time.RFC3339),
+		Messages: make([]messageJSON, 0, len(textMsgs)),
+		Files: files,
+	}
+	if export.Files == nil {
+		export.Files = []FileRef{}
+	}
+	for _, textMsg := range textMsgs {
+		export.Messages = append(export.Messages, textMessageJSON(textMsg))
+	}
+	return export, nil
+}
+
+func writeExport(out io.Writer, export *Export, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(export)
+	case "markdown":
+		return writeMarkdown(out, export)
+	case "html":
+		return exportTemplate.Execute(out, export)
+	case "mbox":
+		return writeMbox(out, export)
+	}
+	return errors.New("unknown export format \"" + format + "\", expected " + strings.Join(exportFormats, ", "))
+}
+
+func messageTime(msg messageJSON) time.Time {
+	return time.Unix(int64(msg.Timestamp), 0)
+}
+
+//Folds line breaks into spaces so a name can't end a header or heading and start another
+func oneLine(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '\r', '\n', '\v', '\f', 0x85, 0x2028, 0x2029:
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+//Sender for the From_ line that separates messages, which splits on spaces
+func mboxSender(username string) string {
+	return strings.Join(strings.Fields(oneLine(username)), "_")
+}
+
+//One section per day so long archives stay readable
+func writeMarkdown(out io.Writer, export *Export) error {
+	var b strings.Builder
+	b.WriteString("# " + oneLine(export.Group) + "\n\nExported " + export.Exported + "\n")
+	day := ""
+	for _, msg := range export.Messages {
+		sent := messageTime(msg)
+		if sentDay := renderer.day(sent); sentDay != day {
+			day = sentDay
+			b.WriteString("\n## " + day + "\n\n")
+		}
+		text := strings.Replace(msg.Message, "\n", "  \n  ", -1)
+		b.WriteString("- **" + renderer.Clock(sent) + " " + oneLine(msg.Username) + "**: " + text + "\n")
+	}
+	if len(export.Files) > 0 {
+		b.WriteString("\n## Files\n\n")
+		for _, ref := range export.Files {
+			sent := time.Unix(int64(ref.Time), 0)
+			b.WriteString("- `" + ref.FileID + "` " + oneLine(ref.Name) + " from " + oneLine(ref.Username) + " at " + renderer.Stamp(sent) + "\n")
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+//mboxrd style, lines starting with From are quoted so readers don't split messages there
+func writeMbox(out io.Writer, export *Export) error {
+	var b strings.Builder
+	for _, msg := range export.Messages {
+		sent := messageTime(msg).UTC()
+		b.WriteString("From " + mboxSender(msg.Username) + "@initchat " + sent.Format(time.ANSIC) + "\n")
+		b.WriteString("From: " + oneLine(msg.Username) + "\n")
+		b.WriteString("Date: " + sent.Format(time.RFC1123Z) + "\n")
+		b.WriteString("Subject: [" + oneLine(export.Group) + "]\n\n")
+		for _, line := range strings.Split(msg.Message, "\n") {
+			if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+				line = ">" + line
+			}
+			b.WriteString(line + "\n")
+		}
+		b.WriteString("\n")
+	}
+	for _, ref := range export.Files {
+		sent := time.Unix(int64(ref.Time), 0).UTC()
+		b.WriteString("From " + mboxSender(ref.Username) + "@initchat " + sent.Format(time.ANSIC) + "\n")
+		b.WriteString("From: " + oneLine(ref.Username) + "\n")
+		b.WriteString("Date: " + sent.Format(time.RFC1123Z) + "\n")
+		b.WriteString("Subject: [" + oneLine(export.Group) + "] File " + oneLine(ref.Name) + "\n\n")
+		b.WriteString("File ID: " + ref.FileID + "\n\n")
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+var exportTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"sent": func(timestamp uint64) string {
+		return renderer.Stamp(time.Unix(int64(timestamp), 0))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Group}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.time { color: #888; }
+.user { font-weight: bold; }
+.message { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Group}}</h1>
+<p>Exported {{.Exported}}</p>
+{{range .Messages}}<p><span class="time">[{{sent .Timestamp}}]</span> <span class="user">{{.Username}}</span>: <span class="message">{{.Message}}</span></p>
+{{end}}{{if .Files}}<h2>Files</h2>
+<ul>
+{{range .Files}}<li><code>{{.FileID}}</code> {{.Name}} from {{.Username}} at {{sent .Time}}</li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+`))
+
+//Writes the group's history to path, in the format given or the one its extension implies
+func exportGroup(hist *History, groupName string, path string, format string) error {
+	if format == "" {
+		format = exportFormatFor(path)
+	}
+	if !validExportFormat(format) {
+		return errors.New("unknown export format \"" + format + "\", expected " + strings.Join(exportFormats, ", "))
+	}
+	export, err := buildExport(hist, groupName)
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		return writeExport(os.Stdout, export, format)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if writeErr := writeExport(file, export, format); writeErr != nil {
+		file.Close()
+		return writeErr
+	}
+	return file.Close()
+}
+
+//Merges a JSON export into the history, into groupName when given or else the group it was exported from.
+//Returns the group and how many messages weren't stored yet.
+func importGroup(hist *History, groupName string, path string) (string, int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+	export := Export{}
+	if parseErr := json.Unmarshal(data, &export); parseErr != nil {
+		return "", 0, errors.New("only JSON exports can be imported: " + parseErr.Error())
+	}
+	if groupName == "" {
+		groupName = export.Group
+	}
+	if groupName == "" {
+		return "", 0, errors.New("export does not name a group")
+	}
+	textMsgs := make([]*Messages.TextMessage, 0, len(export.Messages))
+	for _, msg := range export.Messages {
+		textMsgs = append(textMsgs, &Messages.TextMessage{
+			Username: msg.Username,
+			Message: msg.Message,
+			Time: msg.Timestamp,
+		})
+	}
+	before, err := hist.Messages(groupName)
+	if err != nil {
+		return groupName, 0, err
+	}
+	merged, err := hist.Merge(groupName, textMsgs)
+	if err != nil {
+		return groupName, 0, err
+	}
+	if filesErr := hist.RecordFiles(groupName, export.Files); filesErr != nil {
+		return groupName, 0, filesErr
+	}
+	return groupName, len(merged) - len(before), nil
+}
+
+//Short summary of an import for display
+func importSummary(groupName string, added int) string {
+	return fmt.Sprintf("Imported %d new messages into %s", added, groupName)
+}
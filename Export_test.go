@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+//Names with line breaks in them must not add headers, messages or headings of their own
+func TestExportNamesStayOnOneLine(t *testing.T) {
+	config = defaultConfig()
+	renderer = newMessageRenderer(config)
+	export := &Export{
+		Group: "team\r\nBcc: eve@example.com\n# Fake",
+		Exported: "2026-01-02T03:04:05Z",
+		Messages: []messageJSON{{Username: "mallory\nFrom mallory@initchat Thu Jan  1 00:00:00 1970\nSubject: spoofed", Message: "hi", Timestamp: 1700000000}},
+		Files: []FileRef{{FileID: "file1", Name: "a.pdf\nX-Injected: yes", Username: "bob # Fake", Time: 1700000000}},
+	}
+
+	var mbox strings.Builder
+	if err := writeMbox(&mbox, export); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(mbox.String(), "\n") {
+		if strings.HasPrefix(line, "From ") && len(strings.Fields(line)) != 7 {
+			t.Errorf("mbox separator %q has extra fields", line)
+		}
+		if strings.HasPrefix(line, "Bcc:") || strings.HasPrefix(line, "X-Injected:") || strings.HasPrefix(line, "Subject: spoofed") || strings.HasPrefix(line, "# Fake") {
+			t.Errorf("mbox has injected line %q", line)
+		}
+	}
+	if separators := strings.Count("\n" + mbox.String(), "\nFrom "); separators != 2 {
+		t.Errorf("mbox has %d messages, want 2", separators)
+	}
+
+	var markdown strings.Builder
+	if err := writeMarkdown(&markdown, export); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(markdown.String(), "\n") {
+		if strings.HasPrefix(line, "# Fake") || strings.HasPrefix(line, "Bcc:") || strings.HasPrefix(line, "X-Injected:") {
+			t.Errorf("markdown has injected line %q", line)
+		}
+	}
+	if headings := strings.Count("\n" + markdown.String(), "\n# "); headings != 1 {
+		t.Errorf("markdown has %d top headings, want 1", headings)
+	}
+}
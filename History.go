@@ -0,0 +1,542 @@
+/*
+	Keeps every received message on disk per user and group, encrypted with a key derived from the password
+ */
+
+package main
+
+import (
+	"./Messages"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Largest record accepted when reading a history file back
+const MaxHistoryRecord = 1 << 20
+
+const historyCheckText = "initchat history"
+
+//Open history of the signed in user, nil when history is disabled or the password isn't known
+var history *History
+
+var errHistoryPassword = errors.New("message history was encrypted with a different password")
+var errNoHistory = errors.New("no message history yet, log in with your password to start one")
+
+type History struct {
+	mutex sync.Mutex
+	dir string
+	aead cipher.AEAD
+	//Keys file names to the group without revealing it
+	nameKey []byte
+	//Names of the groups with a history file, kept sealed in the groups file
+	groups map[string]bool
+	//Groups whose file was checked for a record cut short since the history was opened
+	repaired map[string]bool
+}
+
+//Directory of one user's history on the configured server
+func historyUserDir(username string) string {
+	if config.HistoryDir == "" {
+		return ""
+	}
+	server := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(config.Address)
+	userSum := sha256.Sum256([]byte(username))
+	return filepath.Join(config.HistoryDir, server, hex.EncodeToString(userSum[:16]))
+}
+
+//Opens the user's history and derives its key from the password. Only a password the server accepted
+//may start a new history or set aside one sealed with an earlier password, any other fails with
+//errHistoryPassword or errNoHistory and leaves the history as it is.
+func openHistory(username string, password string, accepted bool) (*History, error) {
+	dir := historyUserDir(username)
+	if dir == "" {
+		return nil, nil
+	}
+	saltPath := filepath.Join(dir, "salt")
+	salt, err := ioutil.ReadFile(saltPath)
+	if os.IsNotExist(err) {
+		if !accepted {
+			return nil, errNoHistory
+		}
+		if dirErr := os.MkdirAll(dir, 0700); dirErr != nil {
+			return nil, dirErr
+		}
+		salt = make([]byte, 16)
+		if _, randErr := rand.Read(salt); randErr != nil {
+			return nil, randErr
+		}
+		err = ioutil.WriteFile(saltPath, salt, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys, err := scrypt.Key([]byte(password), salt, 1 << 15, 8, 1, 64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	hist := &History{
+		dir: dir,
+		aead: aead,
+		nameKey: keys[32:],
+		repaired: make(map[string]bool),
+	}
+	if checkErr := hist.checkKey(accepted); checkErr != nil {
+		if checkErr != errHistoryPassword || !accepted {
+			return nil, checkErr
+		}
+		//The password changed since, history sealed with the old one can never be read again
+		oldDir, moveErr := moveAside(dir)
+		if moveErr != nil {
+			return nil, moveErr
+		}
+		log.Println("History was encrypted with a different password, moved to " + oldDir)
+		return openHistory(username, password, accepted)
+	}
+	if groupsErr := hist.loadGroups(); groupsErr != nil {
+		return nil, groupsErr
+	}
+	return hist, nil
+}
+
+func (hist *History) loadGroups() error {
+	hist.groups = make(map[string]bool)
+	sealed, err := ioutil.ReadFile(filepath.Join(hist.dir, "groups"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	plain, openErr := hist.open(sealed)
+	if openErr != nil {
+		return errors.New("history group index could not be decrypted: " + openErr.Error())
+	}
+	var names []string
+	if parseErr := json.Unmarshal(plain, &names); parseErr != nil {
+		return parseErr
+	}
+	for _, name := range names {
+		hist.groups[name] = true
+	}
+	return nil
+}
+
+//Adds the group to the index so searches across all groups can find its file
+func (hist *History) indexGroup(groupName string) error {
+	if hist.groups[groupName] {
+		return nil
+	}
+	hist.groups[groupName] = true
+	plain, err := json.Marshal(hist.groupNames())
+	if err != nil {
+		return err
+	}
+	sealed, err := hist.seal(plain)
+	if err != nil {
+		return err
+	}
+	indexPath := filepath.Join(hist.dir, "groups")
+	if writeErr := ioutil.WriteFile(indexPath + ".tmp", sealed, 0600); writeErr != nil {
+		return writeErr
+	}
+	return os.Rename(indexPath + ".tmp", indexPath)
+}
+
+func (hist *History) groupNames() []string {
+	names := make([]string, 0, len(hist.groups))
+	for name := range hist.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Every group with stored messages
+func (hist *History) Groups() []string {
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+	return hist.groupNames()
+}
+
+//Reads the group's stored messages
+func (hist *History) Messages(groupName string) ([]*Messages.TextMessage, error) {
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+	return hist.load(groupName)
+}
+
+//Renames the directory to a name no other history was set aside under
+func moveAside(dir string) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	oldDir := dir + ".old-" + strconv.FormatInt(time.Now().Unix(), 10) + "-" + hex.EncodeToString(suffix)
+	if _, err := os.Lstat(oldDir); !os.IsNotExist(err) {
+		return "", errors.New("could not set aside history, " + oldDir + " already exists")
+	}
+	if renameErr := os.Rename(dir, oldDir); renameErr != nil {
+		return "", renameErr
+	}
+	return oldDir, nil
+}
+
+//Compares the key against a value sealed when the history was created, sealing it first when create is set
+func (hist *History) checkKey(create bool) error {
+	checkPath := filepath.Join(hist.dir, "check")
+	sealed, err := ioutil.ReadFile(checkPath)
+	if os.IsNotExist(err) {
+		if !create {
+			return errNoHistory
+		}
+		sealed, err = hist.seal([]byte(historyCheckText))
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(checkPath, sealed, 0600)
+	}
+	if err != nil {
+		return err
+	}
+	plain, openErr := hist.open(sealed)
+	if openErr != nil || string(plain) != historyCheckText {
+		return errHistoryPassword
+	}
+	return nil
+}
+
+func (hist *History) seal(plain []byte) ([]byte, error) {
+	nonce := make([]byte, hist.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return hist.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (hist *History) open(sealed []byte) ([]byte, error) {
+	nonceSize := hist.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("history record too short")
+	}
+	return hist.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+}
+
+func (hist *History) groupPath(groupName string) string {
+	mac := hmac.New(sha256.New, hist.nameKey)
+	mac.Write([]byte(groupName))
+	return filepath.Join(hist.dir, hex.EncodeToString(mac.Sum(nil)[:16]) + ".log")
+}
+
+//A file shared in a group, kept next to its messages since files don't arrive as messages
+type FileRef struct {
+	FileID string `json:"fileID"`
+	Name string `json:"name"`
+	Username string `json:"username"`
+	Time uint64 `json:"time"`
+}
+
+func (hist *History) filesPath(groupName string) string {
+	return strings.TrimSuffix(hist.groupPath(groupName), ".log") + ".files"
+}
+
+func (hist *History) loadFiles(groupName string) ([]FileRef, error) {
+	sealed, err := ioutil.ReadFile(hist.filesPath(groupName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	plain, openErr := hist.open(sealed)
+	if openErr != nil {
+		return nil, errors.New("file references could not be decrypted: " + openErr.Error())
+	}
+	var refs []FileRef
+	if parseErr := json.Unmarshal(plain, &refs); parseErr != nil {
+		return nil, parseErr
+	}
+	return refs, nil
+}
+
+//File references of the group in the order they were recorded
+func (hist *History) Files(groupName string) ([]FileRef, error) {
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+	return hist.loadFiles(groupName)
+}
+
+//Stores the references whose file IDs aren't known yet
+func (hist *History) RecordFiles(groupName string, newRefs []FileRef) error {
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+	refs, err := hist.loadFiles(groupName)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		known[ref.FileID] = true
+	}
+	added := false
+	for _, ref := range newRefs {
+		if !known[ref.FileID] {
+			known[ref.FileID] = true
+			refs = append(refs, ref)
+			added = true
+		}
+	}
+	if !added {
+		return nil
+	}
+	if indexErr := hist.indexGroup(groupName); indexErr != nil {
+		return indexErr
+	}
+	plain, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+	sealed, err := hist.seal(plain)
+	if err != nil {
+		return err
+	}
+	path := hist.filesPath(groupName)
+	if writeErr := ioutil.WriteFile(path + ".tmp", sealed, 0600); writeErr != nil {
+		return writeErr
+	}
+	return os.Rename(path + ".tmp", path)
+}
+
+//Reads every stored message of the group, oldest first as they were recorded
+func (hist *History) load(groupName string) ([]*Messages.TextMessage, error) {
+	file, err := os.Open(hist.groupPath(groupName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var textMsgs []*Messages.TextMessage
+	lengthBuf := make([]byte, 4)
+	for {
+		if _, readErr := io.ReadFull(file, lengthBuf); readErr != nil {
+			//A record cut short by a crash is dropped along with anything after it
+			return textMsgs, nil
+		}
+		length := binary.BigEndian.Uint32(lengthBuf)
+		if length > MaxHistoryRecord {
+			return textMsgs, errors.New("history record of " + strconv.FormatUint(uint64(length), 10) + " bytes is too large")
+		}
+		sealed := make([]byte, length)
+		if _, readErr := io.ReadFull(file, sealed); readErr != nil {
+			return textMsgs, nil
+		}
+		plain, openErr := hist.open(sealed)
+		if openErr != nil {
+			return textMsgs, errors.New("history record could not be decrypted: " + openErr.Error())
+		}
+		textMsg := Messages.TextMessage{}
+		if parseErr := proto.Unmarshal(plain, &textMsg); parseErr != nil {
+			return textMsgs, parseErr
+		}
+		textMsgs = append(textMsgs, &textMsg)
+	}
+}
+
+//Cuts a record left incomplete by a crash off the end of the group's file, otherwise records
+//appended after it would be read as part of it and the rest of the history lost
+func (hist *History) repair(groupName string) error {
+	if hist.repaired[groupName] {
+		return nil
+	}
+	file, err := os.OpenFile(hist.groupPath(groupName), os.O_RDWR, 0600)
+	if os.IsNotExist(err) {
+		hist.repaired[groupName] = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	//End of the last complete record
+	var end int64
+	lengthBuf := make([]byte, 4)
+	for end + 4 <= info.Size() {
+		if _, readErr := file.ReadAt(lengthBuf, end); readErr != nil {
+			return readErr
+		}
+		next := end + 4 + int64(binary.BigEndian.Uint32(lengthBuf))
+		if next > info.Size() {
+			break
+		}
+		end = next
+	}
+	if end < info.Size() {
+		if truncateErr := file.Truncate(end); truncateErr != nil {
+			return truncateErr
+		}
+	}
+	hist.repaired[groupName] = true
+	return nil
+}
+
+//Appends the messages to the group's file as separately sealed records
+func (hist *History) append(groupName string, textMsgs []*Messages.TextMessage) error {
+	if len(textMsgs) == 0 {
+		return nil
+	}
+	if indexErr := hist.indexGroup(groupName); indexErr != nil {
+		return indexErr
+	}
+	if repairErr := hist.repair(groupName); repairErr != nil {
+		return repairErr
+	}
+	file, err := os.OpenFile(hist.groupPath(groupName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var records []byte
+	for _, textMsg := range textMsgs {
+		plain, marshalErr := proto.Marshal(textMsg)
+		if marshalErr != nil {
+			return marshalErr
+		}
+		sealed, sealErr := hist.seal(plain)
+		if sealErr != nil {
+			return sealErr
+		}
+		lengthBuf := make([]byte, 4)
+		binary.BigEndian.PutUint32(lengthBuf, uint32(len(sealed)))
+		records = append(records, lengthBuf...)
+		records = append(records, sealed...)
+	}
+	if _, writeErr := file.Write(records); writeErr != nil {
+		//Part of the records may have been written
+		delete(hist.repaired, groupName)
+		return writeErr
+	}
+	return nil
+}
+
+//Stores a message as it arrives
+func (hist *History) Record(groupName string, textMsg *Messages.TextMessage) error {
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+	return hist.append(groupName, []*Messages.TextMessage{textMsg})
+}
+
+func historyKey(textMsg *Messages.TextMessage) string {
+	return strconv.FormatUint(textMsg.Time, 10) + "\x00" + textMsg.Username + "\x00" + textMsg.Message
+}
+
+//Stores the server's messages that aren't kept yet and returns the whole history in time order
+func (hist *History) Merge(groupName string, serverMsgs []*Messages.TextMessage) ([]*Messages.TextMessage, error) {
+	hist.mutex.Lock()
+	defer hist.mutex.Unlock()
+	//Histories kept before the index existed are added when their group is opened
+	if indexErr := hist.indexGroup(groupName); indexErr != nil {
+		return serverMsgs, indexErr
+	}
+	stored, loadErr := hist.load(groupName)
+	if loadErr != nil {
+		return serverMsgs, loadErr
+	}
+	known := make(map[string]bool, len(stored))
+	for _, textMsg := range stored {
+		known[historyKey(textMsg)] = true
+	}
+	var added []*Messages.TextMessage
+	for _, textMsg := range serverMsgs {
+		key := historyKey(textMsg)
+		if !known[key] {
+			known[key] = true
+			added = append(added, textMsg)
+		}
+	}
+	if appendErr := hist.append(groupName, added); appendErr != nil {
+		return serverMsgs, appendErr
+	}
+	merged := append(stored, added...)
+	sort.SliceStable(merged, func(i int, j int) bool {
+		return merged[i].Time < merged[j].Time
+	})
+	return merged, nil
+}
+
+//Opens the history with the password, history stays off when it can't be opened.
+//accepted tells that the server just accepted the password, see openHistory.
+func unlockHistory(username string, password string, accepted bool) {
+	hist, err := openHistory(username, password, accepted)
+	if err != nil {
+		log.Println("Could not open message history: ", err)
+	}
+	history = hist
+}
+
+//The group's messages with the stored history merged in when it is open
+func groupHistory(groupName string, serverMsgs []*Messages.TextMessage) []*Messages.TextMessage {
+	if history == nil {
+		return serverMsgs
+	}
+	merged, err := history.Merge(groupName, serverMsgs)
+	if err != nil {
+		log.Println("Could not read message history: ", err)
+	}
+	return merged
+}
+
+func recordMessage(groupName string, textMsg *Messages.TextMessage) {
+	if history == nil {
+		return
+	}
+	if err := history.Record(groupName, textMsg); err != nil {
+		log.Println("Could not save message: ", err)
+	}
+}
+
+//Remembers a file sent to the group so exports can list it
+func recordFile(groupName string, fileID string, filePath string) {
+	if history == nil {
+		return
+	}
+	ref := FileRef{
+		FileID: fileID,
+		Name: filepath.Base(filePath),
+		Username: client.SessionToken().Username,
+		Time: uint64(time.Now().Unix()),
+	}
+	if err := history.RecordFiles(groupName, []FileRef{ref}); err != nil {
+		log.Println("Could not save file reference: ", err)
+	}
+}
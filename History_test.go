@@ -0,0 +1,151 @@
+package main
+
+import (
+	"./Messages"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//Keeps history under a temporary directory for the test
+func useHistoryDir(t *testing.T) string {
+	t.Helper()
+	config = defaultConfig()
+	config.HistoryDir = t.TempDir()
+	return config.HistoryDir
+}
+
+func testOpenHistory(t *testing.T, username string, password string) *History {
+	t.Helper()
+	hist, err := openHistory(username, password, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hist
+}
+
+func messageTexts(textMsgs []*Messages.TextMessage) []string {
+	var texts []string
+	for _, textMsg := range textMsgs {
+		texts = append(texts, textMsg.Message)
+	}
+	return texts
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	useHistoryDir(t)
+	hist := testOpenHistory(t, "alice", "secret")
+	if err := hist.Record("team", &Messages.TextMessage{Username: "bob", Message: "first", Time: 2}); err != nil {
+		t.Fatal(err)
+	}
+	serverMsgs := []*Messages.TextMessage{
+		{Username: "carol", Message: "older", Time: 1},
+		{Username: "bob", Message: "first", Time: 2},
+	}
+	merged, err := hist.Merge("team", serverMsgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageTexts(merged); !reflect.DeepEqual(got, []string{"older", "first"}) {
+		t.Errorf("Merge() = %v, want the stored message once, in time order", got)
+	}
+
+	reopened := testOpenHistory(t, "alice", "secret")
+	stored, err := reopened.Messages("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageTexts(stored); !reflect.DeepEqual(got, []string{"first", "older"}) {
+		t.Errorf("Messages() after reopening = %v, want both in the order they were recorded", got)
+	}
+	if groups := reopened.Groups(); !reflect.DeepEqual(groups, []string{"team"}) {
+		t.Errorf("Groups() = %v", groups)
+	}
+}
+
+//A password the server never checked, like a mistyped INITCHAT_PASSWORD, must leave the history alone
+func TestHistoryWrongPassword(t *testing.T) {
+	dir := useHistoryDir(t)
+	hist := testOpenHistory(t, "alice", "secret")
+	if err := hist.Record("team", &Messages.TextMessage{Message: "private"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if other, err := openHistory("alice", "typo", false); err != errHistoryPassword {
+			t.Fatalf("openHistory() with a wrong password = %v, %v, want errHistoryPassword", other, err)
+		}
+	}
+	if moved, _ := filepath.Glob(filepath.Join(dir, "*", "*.old-*")); len(moved) != 0 {
+		t.Errorf("wrong password set the history aside to %v", moved)
+	}
+	stored, err := testOpenHistory(t, "alice", "secret").Messages("team")
+	if err != nil || !reflect.DeepEqual(messageTexts(stored), []string{"private"}) {
+		t.Errorf("Messages() with the right password afterwards = %v, %v", messageTexts(stored), err)
+	}
+}
+
+func TestHistoryNotStartedUnchecked(t *testing.T) {
+	dir := useHistoryDir(t)
+	if hist, err := openHistory("alice", "secret", false); err != errNoHistory {
+		t.Fatalf("openHistory() without a history = %v, %v, want errNoHistory", hist, err)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("unchecked password created %d entries", len(entries))
+	}
+}
+
+//After a password change the server accepted, the unreadable history is set aside under a name of its own
+func TestHistoryPasswordChanged(t *testing.T) {
+	dir := useHistoryDir(t)
+	hist := testOpenHistory(t, "alice", "secret")
+	if err := hist.Record("team", &Messages.TextMessage{Message: "private"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{"changed", "changed again"} {
+		other := testOpenHistory(t, "alice", password)
+		if stored, err := other.Messages("team"); err != nil || len(stored) != 0 {
+			t.Errorf("Messages() after changing the password to %q = %v, %v, want a fresh history", password, messageTexts(stored), err)
+		}
+		if err := other.Record("team", &Messages.TextMessage{Message: password}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	moved, _ := filepath.Glob(filepath.Join(dir, "*", "*.old-*"))
+	if len(moved) != 2 {
+		t.Errorf("histories sealed with the old passwords were not both set aside, found %v", moved)
+	}
+}
+
+func TestHistoryTornRecord(t *testing.T) {
+	useHistoryDir(t)
+	hist := testOpenHistory(t, "alice", "secret")
+	if err := hist.Record("team", &Messages.TextMessage{Message: "before"}); err != nil {
+		t.Fatal(err)
+	}
+	//A crash while appending leaves the length of a record but only part of it
+	file, err := os.OpenFile(hist.groupPath("team"), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 0, 100, 1, 2, 3})
+	file.Close()
+
+	reopened := testOpenHistory(t, "alice", "secret")
+	if err := reopened.Record("team", &Messages.TextMessage{Message: "after"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Record("team", &Messages.TextMessage{Message: "later"}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := testOpenHistory(t, "alice", "secret").Messages("team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messageTexts(stored); !reflect.DeepEqual(got, []string{"before", "after", "later"}) {
+		t.Errorf("Messages() after a torn record = %v, want the records on both sides of it", got)
+	}
+}
@@ -0,0 +1,563 @@
+/*
+	Keeps the user's E2E identity sealed with the password, the public keys other users announced,
+	trusted on first use, and which groups encrypt their messages
+ */
+
+package main
+
+import (
+	"./Messages"
+	"./initchat"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Open keyring of the signed in user, nil until someone has signed in
+var keyring *Keyring
+
+var errNoMemberKeys = errors.New("no other member of this group has published an E2E key yet, ask them to turn on ~e2e")
+var errKeyringLocked = errors.New("E2E keys are locked, log in with your password to unlock them")
+var errNoKeyring = errors.New("no E2E keys yet, log in with your password to create them")
+
+type KnownKey struct {
+	//Key trusted on first use or accepted with ~verify, the only one messages are sealed for
+	PublicKey []byte `json:"publicKey"`
+	Verified bool `json:"verified"`
+	//Different key the user announced later, ignored until accepted with ~verify
+	PendingKey []byte `json:"pendingKey,omitempty"`
+}
+
+type Keyring struct {
+	mutex sync.Mutex
+	path string
+	//Nil while the keyring is locked
+	identity *initchat.Identity
+	//Seals the secrets, nil while locked
+	aead cipher.AEAD
+	//Keys of encrypted uploads by file ID, learned from the messages that shared them
+	files map[string]*initchat.FileKey
+	PublicKey []byte `json:"publicKey"`
+	Salt []byte `json:"salt"`
+	//keyringSecrets sealed with a key derived from the password
+	Secrets []byte `json:"secrets"`
+	Users map[string]*KnownKey `json:"users"`
+	//Users who announced a key in each group
+	Members map[string][]string `json:"members"`
+	Encrypted map[string]bool `json:"encrypted"`
+	//Users already answered with our key this run so announcements don't bounce forever
+	answered map[string]bool
+}
+
+//What only the password unlocks
+type keyringSecrets struct {
+	IdentityKey []byte `json:"identityKey"`
+	Files map[string]*initchat.FileKey `json:"files"`
+}
+
+func keyringPath(username string) string {
+	if config.ProfileDir == "" {
+		return ""
+	}
+	server := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(config.Address)
+	userSum := sha256.Sum256([]byte(username))
+	return filepath.Join(config.ProfileDir, server + "-" + hex.EncodeToString(userSum[:8]) + ".keys.json")
+}
+
+//Derives the key the secrets are sealed with from the password, the same way as the history key
+func keyringAEAD(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, 1 << 15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//Loads the user's keyring, unlocking its private key with the password or creating one the first time.
+//Without the password the keyring is locked: it tells which groups are encrypted and whose keys are
+//trusted but can't seal or open messages. Nil when there is nothing to load and no password to create it.
+//When the password doesn't unlock it the locked keyring is returned along with the error.
+func loadKeyring(username string, password string) (*Keyring, error) {
+	kr := &Keyring{
+		path: keyringPath(username),
+		files: make(map[string]*initchat.FileKey),
+		Users: make(map[string]*KnownKey),
+		Members: make(map[string][]string),
+		Encrypted: make(map[string]bool),
+		answered: make(map[string]bool),
+	}
+	if kr.path != "" {
+		data, err := ioutil.ReadFile(kr.path)
+		if err == nil {
+			if parseErr := json.Unmarshal(data, kr); parseErr != nil {
+				return nil, errors.New("could not parse keyring " + kr.path + ": " + parseErr.Error())
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if password == "" {
+		if len(kr.PublicKey) == 0 {
+			return nil, nil
+		}
+		return kr, nil
+	}
+	if len(kr.Salt) == 0 {
+		kr.Salt = make([]byte, 16)
+		if _, err := rand.Read(kr.Salt); err != nil {
+			return nil, err
+		}
+	}
+	aead, err := keyringAEAD(password, kr.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(kr.Secrets) > 0 {
+		secrets, openErr := openKeyringSecrets(aead, kr.Secrets)
+		if openErr != nil {
+			return kr, errors.New("E2E keys were sealed with a different password and stay locked: " + openErr.Error())
+		}
+		identity, parseErr := initchat.ParseIdentity(secrets.IdentityKey)
+		if parseErr != nil {
+			return kr, parseErr
+		}
+		kr.identity = identity
+		kr.aead = aead
+		if secrets.Files != nil {
+			kr.files = secrets.Files
+		}
+		return kr, nil
+	}
+	identity, err := initchat.GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	kr.identity = identity
+	kr.aead = aead
+	kr.PublicKey = identity.PublicKey()
+	return kr, kr.save()
+}
+
+func openKeyringSecrets(aead cipher.AEAD, sealed []byte) (*keyringSecrets, error) {
+	nonceSize := aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("sealed keys too short")
+	}
+	plain, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return nil, err
+	}
+	secrets := &keyringSecrets{}
+	if parseErr := json.Unmarshal(plain, secrets); parseErr != nil {
+		return nil, parseErr
+	}
+	return secrets, nil
+}
+
+//Seals the private key and file keys, which only happens while the keyring is unlocked
+func (kr *Keyring) sealSecrets() error {
+	plain, err := json.Marshal(keyringSecrets{IdentityKey: kr.identity.Bytes(), Files: kr.files})
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, kr.aead.NonceSize())
+	if _, randErr := rand.Read(nonce); randErr != nil {
+		return randErr
+	}
+	kr.Secrets = kr.aead.Seal(nonce, nonce, plain, nil)
+	return nil
+}
+
+//Writes the keyring readable only by the current user, the caller must hold the mutex or own kr
+func (kr *Keyring) save() error {
+	if kr.path == "" {
+		return nil
+	}
+	if kr.aead != nil {
+		if sealErr := kr.sealSecrets(); sealErr != nil {
+			return sealErr
+		}
+	}
+	if dirErr := os.MkdirAll(filepath.Dir(kr.path), 0700); dirErr != nil {
+		return dirErr
+	}
+	data, err := json.Marshal(kr)
+	if err != nil {
+		return err
+	}
+	tmpPath := kr.path + ".tmp"
+	if writeErr := ioutil.WriteFile(tmpPath, data, 0600); writeErr != nil {
+		return writeErr
+	}
+	if chmodErr := os.Chmod(tmpPath, 0600); chmodErr != nil {
+		return chmodErr
+	}
+	return os.Rename(tmpPath, kr.path)
+}
+
+//Whether the private key is unavailable, so messages can't be sealed or opened
+func (kr *Keyring) locked() bool {
+	return kr.identity == nil
+}
+
+func (kr *Keyring) Fingerprint() string {
+	return initchat.Fingerprint(kr.PublicKey)
+}
+
+//Results of learning an announced key
+const (
+	learnedSame = iota
+	learnedNew
+	learnedChanged
+)
+
+//Records a key announced in a group. The first key stays trusted, a different one is only kept
+//as pending, since the server could announce a key of its own in anyone's name.
+func (kr *Keyring) learn(groupName string, username string, publicKey []byte) int {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	result := learnedSame
+	known := kr.Users[username]
+	switch {
+	case known == nil:
+		kr.Users[username] = &KnownKey{PublicKey: publicKey}
+		result = learnedNew
+	case bytes.Equal(known.PublicKey, publicKey) || bytes.Equal(known.PendingKey, publicKey):
+	default:
+		known.PendingKey = publicKey
+		result = learnedChanged
+	}
+	isMember := false
+	for _, member := range kr.Members[groupName] {
+		if member == username {
+			isMember = true
+			break
+		}
+	}
+	if !isMember {
+		kr.Members[groupName] = append(kr.Members[groupName], username)
+		if result == learnedSame {
+			result = learnedNew
+		}
+	}
+	if result != learnedSame {
+		if err := kr.save(); err != nil {
+			log.Println("Could not save keyring: ", err)
+		}
+	}
+	return result
+}
+
+//Whether this run still has to answer the user's key with ours
+func (kr *Keyring) shouldAnswer(groupName string, username string) bool {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	key := groupName + "\x00" + username
+	if kr.answered[key] {
+		return false
+	}
+	kr.answered[key] = true
+	return true
+}
+
+func (kr *Keyring) isEncrypted(groupName string) bool {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	return kr.Encrypted[groupName]
+}
+
+func (kr *Keyring) setEncrypted(groupName string, encrypted bool) error {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	if encrypted {
+		kr.Encrypted[groupName] = true
+	} else {
+		delete(kr.Encrypted, groupName)
+	}
+	return kr.save()
+}
+
+//Our own key and the trusted key of every member who announced one in the group, pending keys are left out
+func (kr *Keyring) recipients(groupName string) [][]byte {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	keys := [][]byte{kr.PublicKey}
+	for _, member := range kr.Members[groupName] {
+		if known := kr.Users[member]; known != nil && len(known.PublicKey) > 0 && !bytes.Equal(known.PublicKey, kr.PublicKey) {
+			keys = append(keys, known.PublicKey)
+		}
+	}
+	return keys
+}
+
+//Members of the group with their key fingerprints and trust
+func (kr *Keyring) memberKeys(groupName string) []string {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	var lines []string
+	for _, member := range kr.Members[groupName] {
+		if known := kr.Users[member]; known != nil {
+			lines = append(lines, knownKeyLine(member, known))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+//The user's trusted key and any pending one, as listed by ~fingerprint and initchat fingerprint
+func knownKeyLine(username string, known *KnownKey) string {
+	line := username + ": "
+	if len(known.PublicKey) > 0 {
+		line += initchat.Fingerprint(known.PublicKey) + " (" + trustLabel(known) + ")"
+	} else {
+		line += "no trusted key"
+	}
+	if len(known.PendingKey) > 0 {
+		line += ", KEY CHANGED to " + initchat.Fingerprint(known.PendingKey) + ", not used until accepted with ~verify"
+	}
+	return line
+}
+
+func trustLabel(known *KnownKey) string {
+	if known.Verified {
+		return "verified"
+	}
+	return "unverified"
+}
+
+//Marks the user's key verified when the fingerprint read out of band matches it. Matching the
+//pending key accepts it in place of the trusted one.
+func (kr *Keyring) verify(username string, fingerprint string) error {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	known := kr.Users[username]
+	if known == nil {
+		return errors.New(username + " has not published an E2E key")
+	}
+	normalize := func(s string) string {
+		return strings.ToLower(strings.Replace(s, " ", "", -1))
+	}
+	matches := func(publicKey []byte) bool {
+		return len(publicKey) > 0 && normalize(fingerprint) == normalize(initchat.Fingerprint(publicKey))
+	}
+	switch {
+	case matches(known.PendingKey):
+		known.PublicKey = known.PendingKey
+		known.PendingKey = nil
+	case matches(known.PublicKey):
+	default:
+		return errors.New("fingerprint does not match the key " + username + " published")
+	}
+	known.Verified = true
+	return kr.save()
+}
+
+//Remembers the key of an encrypted upload so it can be downloaded later
+func (kr *Keyring) addFile(fileKey *initchat.FileKey) {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	if known := kr.files[fileKey.FileID]; known != nil && bytes.Equal(known.Key, fileKey.Key) {
+		return
+	}
+	kr.files[fileKey.FileID] = fileKey
+	if err := kr.save(); err != nil {
+		log.Println("Could not save keyring: ", err)
+	}
+}
+
+//Key of an encrypted upload, nil when the file was uploaded in the clear
+func (kr *Keyring) fileKey(fileID string) *initchat.FileKey {
+	if kr == nil {
+		return nil
+	}
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	return kr.files[fileID]
+}
+
+//Loads the keyring after signing in, unlocked when the password is known.
+//E2E stays off when it can't be loaded.
+func unlockKeyring(username string, password string) {
+	kr, err := loadKeyring(username, password)
+	if err != nil {
+		log.Println("Could not load E2E keys: ", err)
+	}
+	keyring = kr
+}
+
+//Text to send to the group, sealed for every member's key when the group has E2E turned on
+func outgoingText(groupName string, text string) (string, error) {
+	if keyring == nil || !keyring.isEncrypted(groupName) {
+		return text, nil
+	}
+	if keyring.locked() {
+		return "", errKeyringLocked
+	}
+	recipients := keyring.recipients(groupName)
+	if len(recipients) < 2 {
+		return "", errNoMemberKeys
+	}
+	return keyring.identity.Seal(text, recipients)
+}
+
+//A received message after decryption
+type openedText struct {
+	//Nil for key announcements, which aren't shown as messages
+	Message *Messages.TextMessage
+	//How the message was protected, shown next to it
+	Status string
+	//Shown on its own line, such as a changed key warning
+	Notice string
+}
+
+//Learns announced keys and decrypts sealed messages. Live messages answer new members with our key.
+func openText(groupName string, textMsg *Messages.TextMessage, live bool) openedText {
+	if keyring == nil {
+		return openedText{Message: textMsg}
+	}
+	if publicKey, ok := initchat.ParseKeyAnnouncement(textMsg.Message); ok {
+		opened := openedText{}
+		switch keyring.learn(groupName, textMsg.Username, publicKey) {
+		case learnedChanged:
+			opened.Notice = "WARNING: " + textMsg.Username + " announced a different E2E key " + initchat.Fingerprint(publicKey) +
+				". Messages stay encrypted for their old key until you check the new one with them and run ~verify " +
+				textMsg.Username + " {fingerprint}"
+		case learnedNew:
+			if live {
+				opened.Notice = textMsg.Username + " published E2E key " + initchat.Fingerprint(publicKey)
+				if keyring.isEncrypted(groupName) && keyring.shouldAnswer(groupName, textMsg.Username) {
+					announceKey()
+				}
+			}
+		}
+		return opened
+	}
+	if !initchat.IsSealed(textMsg.Message) {
+		opened := openedText{Message: textMsg}
+		if keyring.isEncrypted(groupName) {
+			opened.Status = "not encrypted"
+		}
+		return opened
+	}
+	if keyring.locked() {
+		return openedText{
+			Message: textMsg,
+			Notice: "Could not decrypt a message from " + textMsg.Username + ": " + errKeyringLocked.Error(),
+		}
+	}
+	plain, senderKey, err := keyring.identity.Open(textMsg.Message)
+	if err != nil {
+		return openedText{
+			Message: textMsg,
+			Notice: "Could not decrypt a message from " + textMsg.Username + ": " + err.Error(),
+		}
+	}
+	if fileKey, ok := initchat.ParseFileKeyText(plain); ok {
+		keyring.addFile(fileKey)
+		plain = fileKeyDescription(fileKey)
+	}
+	opened := openedText{
+		Message: &Messages.TextMessage{
+			Username: textMsg.Username,
+			Message: plain,
+			Time: textMsg.Time,
+		},
+	}
+	keyring.mutex.Lock()
+	known := keyring.Users[textMsg.Username]
+	switch {
+	case bytes.Equal(senderKey, keyring.PublicKey):
+		opened.Status = "e2e"
+	case known == nil:
+		opened.Status = "e2e, unknown sender key"
+	case bytes.Equal(known.PublicKey, senderKey):
+		opened.Status = "e2e, " + trustLabel(known)
+	case bytes.Equal(known.PendingKey, senderKey):
+		opened.Status = "e2e, CHANGED KEY NOT ACCEPTED"
+	default:
+		opened.Status = "e2e, SENDER KEY MISMATCH"
+	}
+	keyring.mutex.Unlock()
+	return opened
+}
+
+//Uploads the file, encrypting it first when the group has E2E turned on and sharing its key in a sealed message
+func uploadFile(ctx context.Context, groupName string, filePath string, progress initchat.ProgressFunc) (string, error) {
+	if keyring == nil || !keyring.isEncrypted(groupName) {
+		return client.Upload(ctx, filePath, progress)
+	}
+	if keyring.locked() {
+		return "", errKeyringLocked
+	}
+	//Nobody could decrypt the file, so don't upload it at all
+	if len(keyring.recipients(groupName)) < 2 {
+		return "", errNoMemberKeys
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", errors.New("Could not load file: " + err.Error())
+	}
+	fileID, key, err := client.UploadEncrypted(ctx, filePath, progress)
+	if err != nil {
+		return "", err
+	}
+	fileKey := &initchat.FileKey{
+		FileID: fileID,
+		Name: filepath.Base(filePath),
+		Size: uint64(info.Size()),
+		Key: key,
+	}
+	keyring.addFile(fileKey)
+	sealed, err := outgoingText(groupName, initchat.FileKeyText(*fileKey))
+	if err != nil {
+		return fileID, err
+	}
+	sendCtx, cancel := requestContext()
+	defer cancel()
+	if sendErr := client.SendText(sendCtx, sealed); sendErr != nil {
+		return fileID, errors.New("file was uploaded but its key could not be shared: " + sendErr.Error())
+	}
+	return fileID, nil
+}
+
+//Downloads the file, decrypting it when its key was shared with us
+func downloadFile(ctx context.Context, fileID string, dir string, progress initchat.ProgressFunc) (string, error) {
+	if fileKey := keyring.fileKey(fileID); fileKey != nil {
+		return client.DownloadEncrypted(ctx, *fileKey, dir, progress)
+	}
+	return client.Download(ctx, fileID, dir, progress)
+}
+
+//What a shared encrypted file is shown as in place of its key
+func fileKeyDescription(fileKey *initchat.FileKey) string {
+	return "[file] " + filepath.Base(fileKey.Name) + " (" + strconv.FormatUint((fileKey.Size + 1023) / 1024, 10) +
+		" KB), ~download " + fileKey.FileID
+}
+
+//Publishes our key to the open group
+func announceKey() {
+	ctx, cancel := requestContext()
+	defer cancel()
+	if err := client.SendText(ctx, initchat.KeyAnnouncement(keyring.PublicKey)); err != nil {
+		log.Println("Could not publish E2E key: ", err)
+	}
+}
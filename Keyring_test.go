@@ -0,0 +1,193 @@
+package main
+
+import (
+	"./Messages"
+	"./initchat"
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+//Points the keyring at a temporary profile and opens alice's keyring with the password
+func testOpenKeyring(t *testing.T, password string) *Keyring {
+	t.Helper()
+	config = defaultConfig()
+	config.Address = "chat.example.com:443"
+	config.ProfileDir = t.TempDir()
+	kr, err := loadKeyring("alice", password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring = kr
+	t.Cleanup(func() {
+		keyring = nil
+	})
+	return kr
+}
+
+func mustIdentity(t *testing.T) *initchat.Identity {
+	t.Helper()
+	identity, err := initchat.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+func hasKey(keys [][]byte, publicKey []byte) bool {
+	for _, key := range keys {
+		if bytes.Equal(key, publicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestKeyringLearn(t *testing.T) {
+	kr := testOpenKeyring(t, "secret")
+	bob := mustIdentity(t)
+	if result := kr.learn("team", "bob", bob.PublicKey()); result != learnedNew {
+		t.Errorf("first announcement learned %d, want learnedNew", result)
+	}
+	if result := kr.learn("team", "bob", bob.PublicKey()); result != learnedSame {
+		t.Errorf("repeated announcement learned %d, want learnedSame", result)
+	}
+	if result := kr.learn("design", "bob", bob.PublicKey()); result != learnedNew {
+		t.Errorf("announcement in another group learned %d, want learnedNew", result)
+	}
+	recipients := kr.recipients("team")
+	if len(recipients) != 2 || !hasKey(recipients, kr.PublicKey) || !hasKey(recipients, bob.PublicKey()) {
+		t.Errorf("recipients are %d keys, want ours and bob's", len(recipients))
+	}
+}
+
+//A server announcing its own key in bob's name must not get his messages until the user accepts it
+func TestKeyringChangedKeyPending(t *testing.T) {
+	kr := testOpenKeyring(t, "secret")
+	bob := mustIdentity(t)
+	impostor := mustIdentity(t)
+	kr.learn("team", "bob", bob.PublicKey())
+	if err := kr.setEncrypted("team", true); err != nil {
+		t.Fatal(err)
+	}
+
+	opened := openText("team", &Messages.TextMessage{Username: "bob", Message: initchat.KeyAnnouncement(impostor.PublicKey())}, false)
+	if !strings.Contains(opened.Notice, "WARNING") {
+		t.Errorf("changed key notice is %q", opened.Notice)
+	}
+	if known := kr.Users["bob"]; !bytes.Equal(known.PublicKey, bob.PublicKey()) || !bytes.Equal(known.PendingKey, impostor.PublicKey()) {
+		t.Fatal("changed key replaced the trusted one instead of waiting as pending")
+	}
+	if recipients := kr.recipients("team"); hasKey(recipients, impostor.PublicKey()) || !hasKey(recipients, bob.PublicKey()) {
+		t.Fatal("recipients include the pending key or lost the trusted one")
+	}
+	if kr.learn("team", "bob", impostor.PublicKey()) != learnedSame {
+		t.Error("announcing the pending key again warned twice")
+	}
+
+	sealed, err := outgoingText("team", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, openErr := impostor.Open(sealed); openErr != initchat.ErrNotRecipient {
+		t.Errorf("pending key opened our message: %v", openErr)
+	}
+	if text, _, openErr := bob.Open(sealed); openErr != nil || text != "hello" {
+		t.Errorf("trusted key could not open our message: %q, %v", text, openErr)
+	}
+
+	fromImpostor, err := impostor.Seal("hi", [][]byte{kr.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened = openText("team", &Messages.TextMessage{Username: "bob", Message: fromImpostor}, false)
+	if opened.Status != "e2e, CHANGED KEY NOT ACCEPTED" {
+		t.Errorf("message under the pending key has status %q", opened.Status)
+	}
+
+	reloaded, err := loadKeyring("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if known := reloaded.Users["bob"]; !bytes.Equal(known.PublicKey, bob.PublicKey()) || !bytes.Equal(known.PendingKey, impostor.PublicKey()) {
+		t.Fatal("pending key did not survive a reload")
+	}
+
+	if err := kr.verify("bob", initchat.Fingerprint(mustIdentity(t).PublicKey())); err == nil {
+		t.Error("verify accepted a fingerprint of neither key")
+	}
+	if err := kr.verify("bob", initchat.Fingerprint(impostor.PublicKey())); err != nil {
+		t.Fatal(err)
+	}
+	known := kr.Users["bob"]
+	if !bytes.Equal(known.PublicKey, impostor.PublicKey()) || known.PendingKey != nil || !known.Verified {
+		t.Error("verify did not accept the pending key")
+	}
+	if recipients := kr.recipients("team"); !hasKey(recipients, impostor.PublicKey()) || hasKey(recipients, bob.PublicKey()) {
+		t.Error("recipients did not switch to the accepted key")
+	}
+}
+
+func TestKeyringSealedAtRest(t *testing.T) {
+	kr := testOpenKeyring(t, "secret")
+	bob := mustIdentity(t)
+	kr.learn("team", "bob", bob.PublicKey())
+	kr.addFile(&initchat.FileKey{FileID: "file1", Key: bytes.Repeat([]byte{7}, 32)})
+
+	data, err := ioutil.ReadFile(kr.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("identityKey")) || bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(kr.identity.Bytes()))) {
+		t.Fatal("keyring file holds the private key in the clear")
+	}
+	if bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))) {
+		t.Fatal("keyring file holds a file key in the clear")
+	}
+
+	reloaded, err := loadKeyring("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.locked() || !bytes.Equal(reloaded.identity.Bytes(), kr.identity.Bytes()) {
+		t.Fatal("password did not unlock the same identity")
+	}
+	if reloaded.fileKey("file1") == nil {
+		t.Error("file key was lost")
+	}
+}
+
+func TestKeyringLocked(t *testing.T) {
+	kr := testOpenKeyring(t, "secret")
+	if err := kr.setEncrypted("team", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"", "wrong"} {
+		locked, err := loadKeyring("alice", password)
+		if password != "" && err == nil {
+			t.Errorf("password %q unlocked the keyring", password)
+		}
+		if locked == nil || !locked.locked() {
+			t.Fatalf("password %q did not leave the keyring locked", password)
+		}
+		if locked.Fingerprint() != kr.Fingerprint() {
+			t.Errorf("locked keyring has fingerprint %s, want %s", locked.Fingerprint(), kr.Fingerprint())
+		}
+		keyring = locked
+		if _, sendErr := outgoingText("team", "hello"); sendErr != errKeyringLocked {
+			t.Errorf("sending with password %q = %v, want errKeyringLocked", password, sendErr)
+		}
+	}
+	if reopened, err := loadKeyring("alice", "secret"); err != nil || reopened.locked() {
+		t.Fatalf("wrong password damaged the keyring: %v", err)
+	}
+}
+
+func TestKeyringNoPassword(t *testing.T) {
+	testOpenKeyring(t, "")
+	if keyring != nil {
+		t.Fatal("keyring was created without a password to seal it")
+	}
+}
@@ -1,47 +1,70 @@
 package main
 
 import (
-	"crypto/tls"
-	"crypto/x509"
-	"fmt"
-	"io/ioutil"
+	"./initchat"
+	"flag"
 	"log"
-	"net"
+	"os"
 )
 
+var client *initchat.Client
+
+//Client certificate for mutual TLS, nil when none is configured
+var clientCert *initchat.CertificateLoader
+
 func main() {
-	caData, fErr := ioutil.ReadFile("./tls/rootCA.crt")
-	if fErr != nil {
-		log.Fatalln("Could not load rootCA: ", fErr)
+	cfg, args, cfgErr := loadConfig(os.Args[1:])
+	if cfgErr == flag.ErrHelp {
 		return
 	}
-	roots := x509.NewCertPool()
-	ok := roots.AppendCertsFromPEM(caData)
-	if !ok {
-		log.Fatal("failed to parse root certificate")
-		return
+	if cfgErr != nil {
+		log.Println("Invalid configuration: ", cfgErr)
+		os.Exit(ExitUsage)
 	}
-	tlsConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
-	connection, err := net.Dial("tcp", "127.0.0.1:2750")
-
+	config = cfg
+	renderer = newMessageRenderer(config)
+	if len(args) > 0 {
+		if _, ok := offlineCommands[args[0]]; ok {
+			//Commands keep stdout for their results
+			ui = newLineUI(os.Stderr)
+			os.Exit(runCommand(args))
+		}
+		if _, ok := commands[args[0]]; !ok {
+			log.Println("Unknown command \"" + args[0] + "\", run initchat -h for usage")
+			os.Exit(ExitUsage)
+		}
+	}
+	tlsConfig, certLoader, tlsErr := config.tlsConfig()
+	if tlsErr != nil {
+		log.Println("TLS configuration error: ", tlsErr)
+		os.Exit(ExitUsage)
+	}
+	clientCert = certLoader
+	warnCertificateExpiry()
+	dialer := &initchat.Dialer{TLSConfig: tlsConfig, Proxy: config.Proxy}
+	dial := func() (initchat.Transport, error) {
+		return dialer.Dial(config.Address)
+	}
+	connected, err := initchat.Connect(dial)
 	if err != nil {
-		log.Fatalln("CONNECTION ERROR: ", err)
+		log.Println("CONNECTION ERROR: ", err)
+		os.Exit(ExitUnreachable)
 	}
-	conn := tls.Client(connection, tlsConfig)
-	fmt.Println("Connection established")
-	recvMsgChannel := make(chan *Message)
-	disconnectChannel := make(chan *Client)
-	client = &Client{
-		connection: conn,
-		sendChannel: make(chan *Message),
-		recvChannel: recvMsgChannel,
-		disconnectChannel: disconnectChannel,
+	client = connected
+
+	if len(args) > 0 {
+		ui = newLineUI(os.Stderr)
+		code := runCommand(args)
+		client.Close()
+		os.Exit(code)
 	}
-	go client.runSend()
-	go client.runRead()
-	go runNetEvents(recvMsgChannel, disconnectChannel)
 
-	fmt.Println("InitChat")
-	fmt.Println("---------------------")
+	ui = newUI(config.UIMode)
+	defer ui.Close()
+	ui.SetStatus("Connected to " + config.Address)
+	go showConnectionStates(client.States())
+
+	ui.Println("InitChat")
+	ui.Println("---------------------")
 	readAuthSelection()
 }
\ No newline at end of file
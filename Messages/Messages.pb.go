@@ -23,6 +23,7 @@ const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 type Header struct {
 	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Length               int32    `protobuf:"varint,2,opt,name=length,proto3" json:"length,omitempty"`
+	RequestID            uint32   `protobuf:"varint,3,opt,name=requestID,proto3" json:"requestID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -67,6 +68,13 @@ func (m *Header) GetLength() int32 {
 	return 0
 }
 
+func (m *Header) GetRequestID() uint32 {
+	if m != nil {
+		return m.RequestID
+	}
+	return 0
+}
+
 type SignUpReq struct {
 	Username             string   `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
 	Password             string   `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
@@ -164,6 +172,7 @@ func (m *LoginReq) GetPassword() string {
 type AuthResp struct {
 	Token                string   `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
 	ExpireTime           uint64   `protobuf:"varint,2,opt,name=expireTime,proto3" json:"expireTime,omitempty"`
+	Username             string   `protobuf:"bytes,3,opt,name=username,proto3" json:"username,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -208,6 +217,13 @@ func (m *AuthResp) GetExpireTime() uint64 {
 	return 0
 }
 
+func (m *AuthResp) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
 type UserSearchReq struct {
 	UsernamePrefix       string   `protobuf:"bytes,1,opt,name=usernamePrefix,proto3" json:"usernamePrefix,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -927,6 +943,532 @@ func (m *Error) GetCode() int32 {
 	return 0
 }
 
+type ResumeReq struct {
+	Token                string   `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ResumeReq) Reset()         { *m = ResumeReq{} }
+func (m *ResumeReq) String() string { return proto.CompactTextString(m) }
+func (*ResumeReq) ProtoMessage()    {}
+func (*ResumeReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9eb86ddf19e16901, []int{20}
+}
+
+func (m *ResumeReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ResumeReq.Unmarshal(m, b)
+}
+func (m *ResumeReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ResumeReq.Marshal(b, m, deterministic)
+}
+func (m *ResumeReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ResumeReq.Merge(m, src)
+}
+func (m *ResumeReq) XXX_Size() int {
+	return xxx_messageInfo_ResumeReq.Size(m)
+}
+func (m *ResumeReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_ResumeReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ResumeReq proto.InternalMessageInfo
+
+func (m *ResumeReq) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+type CertLoginReq struct {
+	Username             string   `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CertLoginReq) Reset()         { *m = CertLoginReq{} }
+func (m *CertLoginReq) String() string { return proto.CompactTextString(m) }
+func (*CertLoginReq) ProtoMessage()    {}
+func (*CertLoginReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9eb86ddf19e16901, []int{21}
+}
+
+func (m *CertLoginReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CertLoginReq.Unmarshal(m, b)
+}
+func (m *CertLoginReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CertLoginReq.Marshal(b, m, deterministic)
+}
+func (m *CertLoginReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CertLoginReq.Merge(m, src)
+}
+func (m *CertLoginReq) XXX_Size() int {
+	return xxx_messageInfo_CertLoginReq.Size(m)
+}
+func (m *CertLoginReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_CertLoginReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CertLoginReq proto.InternalMessageInfo
+
+func (m *CertLoginReq) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+type UploadStartReq struct {
+	TransferID           string   `protobuf:"bytes,1,opt,name=transferID,proto3" json:"transferID,omitempty"`
+	Name                 string   `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Size                 uint64   `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	Sha256               []byte   `protobuf:"bytes,4,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UploadStartReq) Reset()         { *m = UploadStartReq{} }
+func (m *UploadStartReq) String() string { return proto.CompactTextString(m) }
+func (*UploadStartReq) ProtoMessage()    {}
+func (*UploadStartReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9eb86ddf19e16901, []int{22}
+}
+
+func (m *UploadStartReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UploadStartReq.Unmarshal(m, b)
+}
+func (m *UploadStartReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UploadStartReq.Marshal(b, m, deterministic)
+}
+func (m *UploadStartReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UploadStartReq.Merge(m, src)
+}
+func (m *UploadStartReq) XXX_Size() int {
+	return xxx_messageInfo_UploadStartReq.Size(m)
+}
+func (m *UploadStartReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_UploadStartReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UploadStartReq proto.InternalMessageInfo
+
+func (m *UploadStartReq) GetTransferID() string {
+	if m != nil {
+		return m.TransferID
+	}
+	return ""
+}
+
+func (m *UploadStartReq) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *UploadStartReq) GetSize() uint64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *UploadStartReq) GetSha256() []byte {
+	if m != nil {
+		return m.Sha256
+	}
+	return nil
+}
+
+type FileChunk struct {
+	TransferID           string   `protobuf:"bytes,1,opt,name=transferID,proto3" json:"transferID,omitempty"`
+	Offset               uint64   `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Data                 []byte   `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FileChunk) Reset()         { *m = FileChunk{} }
+func (m *FileChunk) String() string { return proto.CompactTextString(m) }
+func (*FileChunk) ProtoMessage()    {}
+func (*FileChunk) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9eb86ddf19e16901, []int{23}
+}
+
+func (m *FileChunk) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileChunk.Unmarshal(m, b)
+}
+func (m *FileChunk) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileChunk.Marshal(b, m, deterministic)
+}
+func (m *FileChunk) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileChunk.Merge(m, src)
+}
+func (m *FileChunk) XXX_Size() int {
+	return xxx_messageInfo_FileChunk.Size(m)
+}
+func (m *FileChunk) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileChunk.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileChunk proto.InternalMessageInfo
+
+func (m *FileChunk) GetTransferID() string {
+	if m != nil {
+		return m.TransferID
+	}
+	return ""
+}
+
+func (m *FileChunk) GetOffset() uint64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *FileChunk) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+type UploadEndReq struct {
+	TransferID           string   `protobuf:"bytes,1,opt,name=transferID,proto3" json:"transferID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UploadEndReq) Reset()         { *m = UploadEndReq{} }
+func (m *UploadEndReq) String() string { return proto.CompactTextString(m) }
+func (*UploadEndReq) ProtoMessage()    {}
+func (*UploadEndReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9eb86ddf19e16901, []int{24}
+}
+
+func (m *UploadEndReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UploadEndReq.Unmarshal(m, b)
+}
+func (m *UploadEndReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UploadEndReq.Marshal(b, m, deterministic)
+}
+func (m *UploadEndReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UploadEndReq.Merge(m, src)
+}
+func (m *UploadEndReq) XXX_Size() int {
+	return xxx_messageInfo_UploadEndReq.Size(m)
+}
+func (m *UploadEndReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_UploadEndReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UploadEndReq proto.InternalMessageInfo
+
+func (m *UploadEndReq) GetTransferID() string {
+	if m != nil {
+		return m.TransferID
+	}
+	return ""
+}
+
+type TransferStatus struct {
+	TransferID           string   `protobuf:"bytes,1,opt,name=transferID,proto3" json:"transferID,omitempty"`
+	Offset               uint64   `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	FileID               string   `protobuf:"bytes,3,opt,name=fileID,proto3" json:"fileID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TransferStatus) Reset()         { *m = TransferStatus{} }
+func (m *TransferStatus) String() string { return proto.CompactTextString(m) }
+func (*TransferStatus) ProtoMessage()    {}
+func (*TransferStatus) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9eb86ddf19e16901, []int{25}
+}
+
+func (m *TransferStatus) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TransferStatus.Unmarshal(m, b)
+}
+func (m *TransferStatus) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TransferStatus.Marshal(b, m, deterministic)
+}
+func (m *TransferStatus) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TransferStatus.Merge(m, src)
+}
+func (m *TransferStatus) XXX_Size() int {
+	return xxx_messageInfo_TransferStatus.Size(m)
+}
+func (m *TransferStatus) XXX_DiscardUnknown() {
+	xxx_messageInfo_TransferStatus.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TransferStatus proto.InternalMessageInfo
+
+func (m *TransferStatus) GetTransferID() string {
+	if m != nil {
+		return m.TransferID
+	}
+	return ""
+}
+
+func (m *TransferStatus) GetOffset() uint64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *TransferStatus) GetFileID() string {
+	if m != nil {
+		return m.FileID
+	}
+	return ""
+}
+
+type FileInfo struct {
+	FileID               string   `protobuf:"bytes,1,opt,name=fileID,proto3" json:"fileID,omitempty"`
+	Name                 string   `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Size                 uint64   `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	Sha256               []byte   `protobuf:"bytes,4,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FileInfo) Reset()         { *m = FileInfo{} }
+func (m *FileInfo) String() string { return proto.CompactTextString(m) }
+func (*FileInfo) ProtoMessage()    {}
+func (*FileInfo) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9eb86ddf19e16901, []int{26}
+}
+
+func (m *FileInfo) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileInfo.Unmarshal(m, b)
+}
+func (m *FileInfo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileInfo.Marshal(b, m, deterministic)
+}
+func (m *FileInfo) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileInfo.Merge(m, src)
+}
+func (m *FileInfo) XXX_Size() int {
+	return xxx_messageInfo_FileInfo.Size(m)
+}
+func (m *FileInfo) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileInfo.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileInfo proto.InternalMessageInfo
+
+func (m *FileInfo) GetFileID() string {
+	if m != nil {
+		return m.FileID
+	}
+	return ""
+}
+
+func (m *FileInfo) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *FileInfo) GetSize() uint64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *FileInfo) GetSha256() []byte {
+	if m != nil {
+		return m.Sha256
+	}
+	return nil
+}
+
+type ChunkReq struct {
+	FileID               string   `protobuf:"bytes,1,opt,name=fileID,proto3" json:"fileID,omitempty"`
+	Offset               uint64   `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Length               uint32   `protobuf:"varint,3,opt,name=length,proto3" json:"length,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ChunkReq) Reset()         { *m = ChunkReq{} }
+func (m *ChunkReq) String() string { return proto.CompactTextString(m) }
+func (*ChunkReq) ProtoMessage()    {}
+func (*ChunkReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9eb86ddf19e16901, []int{27}
+}
+
+func (m *ChunkReq) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ChunkReq.Unmarshal(m, b)
+}
+func (m *ChunkReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ChunkReq.Marshal(b, m, deterministic)
+}
+func (m *ChunkReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ChunkReq.Merge(m, src)
+}
+func (m *ChunkReq) XXX_Size() int {
+	return xxx_messageInfo_ChunkReq.Size(m)
+}
+func (m *ChunkReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_ChunkReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ChunkReq proto.InternalMessageInfo
+
+func (m *ChunkReq) GetFileID() string {
+	if m != nil {
+		return m.FileID
+	}
+	return ""
+}
+
+func (m *ChunkReq) GetOffset() uint64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *ChunkReq) GetLength() uint32 {
+	if m != nil {
+		return m.Length
+	}
+	return 0
+}
+
+type E2EEnvelope struct {
+	SenderKey            []byte                    `protobuf:"bytes,1,opt,name=senderKey,proto3" json:"senderKey,omitempty"`
+	Nonce                []byte                    `protobuf:"bytes,2,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Ciphertext           []byte                    `protobuf:"bytes,3,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
+	Keys                 []*E2EEnvelope_WrappedKey `protobuf:"bytes,4,rep,name=keys,proto3" json:"keys,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                  `json:"-"`
+	XXX_unrecognized     []byte                    `json:"-"`
+	XXX_sizecache        int32                     `json:"-"`
+}
+
+func (m *E2EEnvelope) Reset()         { *m = E2EEnvelope{} }
+func (m *E2EEnvelope) String() string { return proto.CompactTextString(m) }
+func (*E2EEnvelope) ProtoMessage()    {}
+func (*E2EEnvelope) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9eb86ddf19e16901, []int{28}
+}
+
+func (m *E2EEnvelope) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_E2EEnvelope.Unmarshal(m, b)
+}
+func (m *E2EEnvelope) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_E2EEnvelope.Marshal(b, m, deterministic)
+}
+func (m *E2EEnvelope) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_E2EEnvelope.Merge(m, src)
+}
+func (m *E2EEnvelope) XXX_Size() int {
+	return xxx_messageInfo_E2EEnvelope.Size(m)
+}
+func (m *E2EEnvelope) XXX_DiscardUnknown() {
+	xxx_messageInfo_E2EEnvelope.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_E2EEnvelope proto.InternalMessageInfo
+
+func (m *E2EEnvelope) GetSenderKey() []byte {
+	if m != nil {
+		return m.SenderKey
+	}
+	return nil
+}
+
+func (m *E2EEnvelope) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
+}
+
+func (m *E2EEnvelope) GetCiphertext() []byte {
+	if m != nil {
+		return m.Ciphertext
+	}
+	return nil
+}
+
+func (m *E2EEnvelope) GetKeys() []*E2EEnvelope_WrappedKey {
+	if m != nil {
+		return m.Keys
+	}
+	return nil
+}
+
+type E2EEnvelope_WrappedKey struct {
+	RecipientKey         []byte   `protobuf:"bytes,1,opt,name=recipientKey,proto3" json:"recipientKey,omitempty"`
+	Nonce                []byte   `protobuf:"bytes,2,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Wrapped              []byte   `protobuf:"bytes,3,opt,name=wrapped,proto3" json:"wrapped,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *E2EEnvelope_WrappedKey) Reset()         { *m = E2EEnvelope_WrappedKey{} }
+func (m *E2EEnvelope_WrappedKey) String() string { return proto.CompactTextString(m) }
+func (*E2EEnvelope_WrappedKey) ProtoMessage()    {}
+func (*E2EEnvelope_WrappedKey) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9eb86ddf19e16901, []int{28, 0}
+}
+
+func (m *E2EEnvelope_WrappedKey) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_E2EEnvelope_WrappedKey.Unmarshal(m, b)
+}
+func (m *E2EEnvelope_WrappedKey) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_E2EEnvelope_WrappedKey.Marshal(b, m, deterministic)
+}
+func (m *E2EEnvelope_WrappedKey) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_E2EEnvelope_WrappedKey.Merge(m, src)
+}
+func (m *E2EEnvelope_WrappedKey) XXX_Size() int {
+	return xxx_messageInfo_E2EEnvelope_WrappedKey.Size(m)
+}
+func (m *E2EEnvelope_WrappedKey) XXX_DiscardUnknown() {
+	xxx_messageInfo_E2EEnvelope_WrappedKey.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_E2EEnvelope_WrappedKey proto.InternalMessageInfo
+
+func (m *E2EEnvelope_WrappedKey) GetRecipientKey() []byte {
+	if m != nil {
+		return m.RecipientKey
+	}
+	return nil
+}
+
+func (m *E2EEnvelope_WrappedKey) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
+}
+
+func (m *E2EEnvelope_WrappedKey) GetWrapped() []byte {
+	if m != nil {
+		return m.Wrapped
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Header)(nil), "Header")
 	proto.RegisterType((*SignUpReq)(nil), "SignUpReq")
@@ -949,43 +1491,71 @@ func init() {
 	proto.RegisterType((*GroupResp)(nil), "GroupResp")
 	proto.RegisterType((*GroupsResp)(nil), "GroupsResp")
 	proto.RegisterType((*Error)(nil), "Error")
+	proto.RegisterType((*ResumeReq)(nil), "ResumeReq")
+	proto.RegisterType((*CertLoginReq)(nil), "CertLoginReq")
+	proto.RegisterType((*UploadStartReq)(nil), "UploadStartReq")
+	proto.RegisterType((*FileChunk)(nil), "FileChunk")
+	proto.RegisterType((*UploadEndReq)(nil), "UploadEndReq")
+	proto.RegisterType((*TransferStatus)(nil), "TransferStatus")
+	proto.RegisterType((*FileInfo)(nil), "FileInfo")
+	proto.RegisterType((*ChunkReq)(nil), "ChunkReq")
+	proto.RegisterType((*E2EEnvelope)(nil), "E2EEnvelope")
+	proto.RegisterType((*E2EEnvelope_WrappedKey)(nil), "E2EEnvelope.WrappedKey")
 }
 
 func init() { proto.RegisterFile("Messages.proto", fileDescriptor_9eb86ddf19e16901) }
 
 var fileDescriptor_9eb86ddf19e16901 = []byte{
-	// 515 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x54, 0xef, 0x8b, 0x13, 0x31,
-	0x10, 0x65, 0x7b, 0xbd, 0x5e, 0x77, 0x5a, 0x57, 0x88, 0x72, 0x94, 0x43, 0x8e, 0x12, 0x50, 0x8b,
-	0xdc, 0x15, 0x51, 0x0e, 0xbf, 0xde, 0x8f, 0xfa, 0xe3, 0x44, 0x45, 0x72, 0x57, 0xfc, 0xbc, 0x76,
-	0xa7, 0x6d, 0x70, 0x9b, 0xc4, 0x24, 0xf5, 0xfa, 0x07, 0xf9, 0x87, 0x4a, 0xb2, 0xd9, 0xed, 0xf6,
-	0xa0, 0xe5, 0xc0, 0x6f, 0x79, 0x2f, 0x6f, 0xde, 0x4c, 0x26, 0x93, 0x40, 0xf2, 0x15, 0x8d, 0x49,
-	0x67, 0x68, 0x86, 0x4a, 0x4b, 0x2b, 0xe9, 0x6b, 0x68, 0x7d, 0xc2, 0x34, 0x43, 0x4d, 0x12, 0x68,
-	0xf0, 0xac, 0x17, 0xf5, 0xa3, 0x41, 0xcc, 0x1a, 0x3c, 0x23, 0x87, 0xd0, 0xca, 0x51, 0xcc, 0xec,
-	0xbc, 0xd7, 0xe8, 0x47, 0x83, 0x7d, 0x16, 0x10, 0xbd, 0x82, 0xf8, 0x86, 0xcf, 0xc4, 0x58, 0x31,
-	0xfc, 0x4d, 0x8e, 0xa0, 0xbd, 0x34, 0xa8, 0x45, 0xba, 0xc0, 0x10, 0x5a, 0x61, 0xb7, 0xa7, 0x52,
-	0x63, 0xee, 0xa4, 0xce, 0xbc, 0x45, 0xcc, 0x2a, 0x4c, 0x2f, 0xa1, 0xfd, 0x45, 0xce, 0xb8, 0xf8,
-	0x1f, 0x8f, 0x73, 0x68, 0x5f, 0x2c, 0xed, 0x9c, 0xa1, 0x51, 0xe4, 0x29, 0xec, 0x5b, 0xf9, 0x0b,
-	0x45, 0x30, 0x28, 0x00, 0x39, 0x06, 0xc0, 0x95, 0xe2, 0x1a, 0x6f, 0xf9, 0x02, 0x7d, 0x7c, 0x93,
-	0xd5, 0x18, 0xfa, 0x0e, 0x1e, 0x8d, 0x0d, 0xea, 0x1b, 0x4c, 0xf5, 0x64, 0xee, 0x4a, 0x79, 0x01,
-	0x49, 0x99, 0xfa, 0xbb, 0xc6, 0x29, 0x5f, 0x05, 0xbf, 0x7b, 0x2c, 0x1d, 0x42, 0x52, 0x0f, 0x34,
-	0x8a, 0x3c, 0x83, 0xb8, 0xd4, 0x98, 0x5e, 0xd4, 0xdf, 0x1b, 0xc4, 0x6c, 0x4d, 0xd0, 0x57, 0x90,
-	0xdc, 0xe2, 0xca, 0x86, 0xde, 0xbb, 0x4c, 0x3d, 0x38, 0x58, 0x14, 0x28, 0xa4, 0x28, 0x21, 0xfd,
-	0x01, 0x9d, 0x9a, 0x76, 0x67, 0x77, 0x6a, 0x26, 0x8d, 0x0d, 0x13, 0x42, 0xa0, 0x69, 0xdd, 0x99,
-	0xf7, 0xfc, 0x99, 0xfd, 0x9a, 0x9e, 0x43, 0xf2, 0x81, 0xe7, 0x58, 0x2b, 0x82, 0x40, 0xb3, 0xe6,
-	0xdb, 0x2c, 0x3b, 0x3e, 0x91, 0xc2, 0xa2, 0xb0, 0xc6, 0x9b, 0x76, 0x59, 0x85, 0xe9, 0x73, 0xe8,
-	0x8c, 0xe4, 0x9d, 0xc8, 0x65, 0x9a, 0xb9, 0xf0, 0x43, 0x68, 0x4d, 0x79, 0x8e, 0xd7, 0xa3, 0x60,
-	0x10, 0x10, 0xbd, 0x84, 0xee, 0x5a, 0x66, 0xd4, 0x36, 0xdd, 0xce, 0x54, 0x7f, 0x23, 0xe8, 0x5c,
-	0x8b, 0x3f, 0xdc, 0xa2, 0xf1, 0x1e, 0xa7, 0x70, 0xc0, 0x0b, 0xe8, 0xbb, 0xdb, 0x79, 0xf3, 0x64,
-	0x58, 0xdb, 0x0e, 0x6b, 0x56, 0x6a, 0x8e, 0xa6, 0xd0, 0x2a, 0x28, 0x97, 0xa4, 0x20, 0xab, 0xf4,
-	0x15, 0x26, 0x14, 0xba, 0x53, 0x2d, 0x17, 0xe3, 0xb2, 0xbf, 0x45, 0x13, 0x37, 0x38, 0x77, 0xb1,
-	0x33, 0x2d, 0x97, 0xea, 0x5b, 0x1a, 0xda, 0x19, 0xb3, 0x35, 0x41, 0x5f, 0x42, 0x1c, 0x52, 0xef,
-	0x1e, 0x64, 0x7a, 0x0a, 0x8f, 0x2f, 0x26, 0x13, 0x54, 0x76, 0x43, 0xbe, 0xad, 0x32, 0x27, 0x1f,
-	0x61, 0x8e, 0x16, 0x1f, 0x26, 0x1f, 0x42, 0x72, 0xa5, 0x31, 0xb5, 0xf8, 0xd1, 0x55, 0xe6, 0xd4,
-	0x1b, 0x65, 0x47, 0xf7, 0xcb, 0x3e, 0x81, 0xee, 0x67, 0xc9, 0xc5, 0x03, 0xd5, 0x67, 0x10, 0x07,
-	0xa5, 0x51, 0x64, 0x00, 0xed, 0x30, 0x64, 0xe5, 0x4d, 0x74, 0x87, 0xf5, 0xd9, 0xae, 0x76, 0xe9,
-	0x09, 0x80, 0x0f, 0x2b, 0x2e, 0xf0, 0x18, 0xa0, 0x72, 0x2c, 0x5f, 0x48, 0x8d, 0xa1, 0x67, 0xb0,
-	0xff, 0x5e, 0x6b, 0xa9, 0xb7, 0xbf, 0x0c, 0x37, 0xae, 0x13, 0x99, 0x61, 0xf8, 0x8f, 0xfc, 0xfa,
-	0x67, 0xcb, 0x7f, 0x63, 0x6f, 0xff, 0x05, 0x00, 0x00, 0xff, 0xff, 0x82, 0x82, 0xac, 0x46, 0xd8,
-	0x04, 0x00, 0x00,
+	// 801 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x56, 0x5f, 0x6f, 0x23, 0x35,
+	0x10, 0x57, 0xd2, 0x6d, 0x9a, 0x9d, 0xec, 0x2d, 0xd2, 0x82, 0x4a, 0x54, 0xa1, 0xaa, 0x58, 0x02,
+	0xa2, 0xe3, 0x2e, 0x0f, 0x45, 0x85, 0x57, 0xee, 0xda, 0x00, 0xe5, 0xe0, 0x84, 0xdc, 0x56, 0x7d,
+	0xe1, 0xe1, 0xf6, 0x76, 0x67, 0x13, 0xab, 0x89, 0xed, 0xb3, 0x9d, 0x6b, 0xcb, 0xe7, 0xe1, 0x03,
+	0xf2, 0x11, 0x90, 0xbd, 0xde, 0x7f, 0x27, 0x12, 0xaa, 0xeb, 0x9b, 0x7f, 0x3f, 0x8f, 0xe7, 0x37,
+	0x1e, 0xcf, 0xcc, 0x2e, 0xc4, 0xbf, 0xa3, 0xd6, 0xe9, 0x1c, 0xf5, 0x54, 0x2a, 0x61, 0x04, 0x79,
+	0x0d, 0x83, 0x5f, 0x30, 0xcd, 0x51, 0x25, 0x31, 0xf4, 0x59, 0x3e, 0xee, 0x1d, 0xf5, 0x26, 0x21,
+	0xed, 0xb3, 0x3c, 0xd9, 0x87, 0xc1, 0x12, 0xf9, 0xdc, 0x2c, 0xc6, 0xfd, 0xa3, 0xde, 0x64, 0x97,
+	0x7a, 0x94, 0x7c, 0x01, 0xa1, 0xc2, 0x77, 0x6b, 0xd4, 0xe6, 0xfc, 0x6c, 0xbc, 0x73, 0xd4, 0x9b,
+	0x3c, 0xa1, 0x0d, 0x41, 0x4e, 0x21, 0xbc, 0x60, 0x73, 0x7e, 0x25, 0x29, 0xbe, 0x4b, 0x0e, 0x60,
+	0xb8, 0xd6, 0xa8, 0x78, 0xba, 0x42, 0xef, 0xb8, 0xc6, 0x76, 0x4f, 0xa6, 0x5a, 0xdf, 0x0a, 0x95,
+	0x3b, 0x81, 0x90, 0xd6, 0x98, 0xbc, 0x84, 0xe1, 0x6f, 0x62, 0xce, 0xf8, 0x63, 0x7c, 0xfc, 0x09,
+	0xc3, 0x17, 0x6b, 0xb3, 0xa0, 0xa8, 0x65, 0xf2, 0x19, 0xec, 0x1a, 0x71, 0x83, 0xdc, 0x3b, 0x28,
+	0x41, 0x72, 0x08, 0x80, 0x77, 0x92, 0x29, 0xbc, 0x64, 0x2b, 0x74, 0xe7, 0x03, 0xda, 0x62, 0x3a,
+	0xca, 0x3b, 0x5d, 0x65, 0xf2, 0x03, 0x3c, 0xb9, 0xd2, 0xa8, 0x2e, 0x30, 0x55, 0xd9, 0xc2, 0x86,
+	0xf9, 0x35, 0xc4, 0xd5, 0xe6, 0x1f, 0x0a, 0x0b, 0x76, 0xe7, 0xb5, 0x3e, 0x60, 0xc9, 0x14, 0xe2,
+	0xf6, 0x41, 0x2d, 0x6d, 0x3e, 0x2b, 0x1b, 0x3d, 0xee, 0x1d, 0xed, 0x4c, 0x42, 0xda, 0x10, 0xe4,
+	0x29, 0xc4, 0x97, 0x78, 0x67, 0xfc, 0xab, 0x59, 0xa5, 0x31, 0xec, 0xad, 0x4a, 0xe4, 0x25, 0x2a,
+	0x48, 0xae, 0x61, 0xd4, 0xb2, 0xdd, 0x9a, 0xb9, 0x96, 0x93, 0x7e, 0xc7, 0x49, 0x92, 0x40, 0x60,
+	0x98, 0xbf, 0x71, 0x40, 0xdd, 0x9a, 0xfc, 0x08, 0xf1, 0x4f, 0x6c, 0x89, 0xad, 0x20, 0x12, 0x08,
+	0x5a, 0x7e, 0x83, 0xea, 0x35, 0x32, 0xc1, 0x0d, 0x72, 0xa3, 0x9d, 0xd3, 0x88, 0xd6, 0x98, 0x7c,
+	0x05, 0xa3, 0x33, 0x71, 0xcb, 0x97, 0x22, 0xcd, 0xed, 0xf1, 0x7d, 0x18, 0x14, 0x6c, 0x89, 0xe7,
+	0x67, 0xde, 0x81, 0x47, 0xe4, 0x25, 0x44, 0x8d, 0x99, 0x96, 0x9b, 0xec, 0xb6, 0x4a, 0xfd, 0xdd,
+	0x83, 0xd1, 0x39, 0x7f, 0xcf, 0x0c, 0x6a, 0xe7, 0xe3, 0x39, 0xec, 0xb1, 0x12, 0xba, 0xec, 0x8e,
+	0x8e, 0x3f, 0x9d, 0xb6, 0xb6, 0xfd, 0x9a, 0x56, 0x36, 0x07, 0x05, 0x0c, 0x4a, 0xca, 0x8a, 0x94,
+	0x64, 0x2d, 0x5f, 0xe3, 0x84, 0x40, 0x54, 0x28, 0xb1, 0xba, 0xaa, 0xf2, 0x5b, 0x26, 0xb1, 0xc3,
+	0xd9, 0x87, 0x9d, 0x2b, 0xb1, 0x96, 0xaf, 0x9b, 0x02, 0x6a, 0x08, 0xf2, 0x0d, 0x84, 0x5e, 0x7a,
+	0x7b, 0x91, 0x93, 0xe7, 0xf0, 0xc9, 0x8b, 0x2c, 0x43, 0x69, 0x3a, 0xe6, 0x9b, 0x22, 0xb3, 0xe6,
+	0x67, 0xb8, 0x44, 0x83, 0x0f, 0x33, 0x9f, 0x42, 0x7c, 0xaa, 0x30, 0x35, 0xf8, 0xb3, 0x8d, 0xcc,
+	0x5a, 0x77, 0xc2, 0xee, 0x7d, 0x18, 0xf6, 0x33, 0x88, 0x7e, 0x15, 0x8c, 0x3f, 0xd0, 0xfa, 0x04,
+	0x42, 0x6f, 0xa9, 0x65, 0x32, 0x81, 0xa1, 0x2f, 0xb2, 0xea, 0x25, 0xa2, 0x69, 0xbb, 0xb6, 0xeb,
+	0x5d, 0xf2, 0x0c, 0xc0, 0x1d, 0x2b, 0x1f, 0xf0, 0x10, 0xa0, 0xf6, 0x58, 0x75, 0x48, 0x8b, 0x21,
+	0x27, 0xb0, 0x3b, 0x53, 0x4a, 0xa8, 0xcd, 0x9d, 0x61, 0xcb, 0x35, 0x13, 0x39, 0xfa, 0x49, 0xe6,
+	0xd6, 0xe4, 0x4b, 0x08, 0x29, 0xea, 0xf5, 0xca, 0xa5, 0xe8, 0x3f, 0x27, 0x04, 0x79, 0x0a, 0xd1,
+	0x29, 0x2a, 0xf3, 0x90, 0x59, 0x44, 0x24, 0xc4, 0x57, 0xd2, 0x16, 0xee, 0x85, 0x49, 0x95, 0xb1,
+	0xd6, 0x87, 0x00, 0x46, 0xa5, 0x5c, 0x17, 0xa8, 0xea, 0xc4, 0xb7, 0x98, 0xba, 0x87, 0xfa, 0xad,
+	0x1e, 0x4a, 0x20, 0xd0, 0xec, 0xaf, 0xba, 0xfb, 0xec, 0xda, 0x36, 0x81, 0x5e, 0xa4, 0xc7, 0x27,
+	0xdf, 0x8f, 0x03, 0x57, 0xea, 0x1e, 0x91, 0x6b, 0x08, 0x6d, 0x57, 0x9e, 0x2e, 0xd6, 0xfc, 0xe6,
+	0x7f, 0xc5, 0xf6, 0x61, 0x20, 0x8a, 0x42, 0xa3, 0xf1, 0x83, 0xce, 0x23, 0x2b, 0x98, 0xa7, 0x26,
+	0x75, 0x82, 0x11, 0x75, 0x6b, 0x32, 0x85, 0xa8, 0xbc, 0xca, 0x8c, 0xe7, 0x0f, 0xb8, 0x08, 0x79,
+	0x03, 0xf1, 0xa5, 0x47, 0x17, 0x26, 0x35, 0x6b, 0xfd, 0xd1, 0xd1, 0x34, 0xfd, 0xbe, 0xd3, 0x99,
+	0x0b, 0x6f, 0x61, 0x68, 0xaf, 0x7a, 0xce, 0x0b, 0xb1, 0x71, 0x26, 0x3c, 0x36, 0x9d, 0x14, 0x86,
+	0x2e, 0x95, 0x5b, 0xe6, 0xd3, 0xb6, 0xb8, 0xfd, 0xb7, 0xb2, 0xfc, 0x20, 0x7a, 0x44, 0xfe, 0xe9,
+	0xc1, 0x68, 0x76, 0x3c, 0x9b, 0xf1, 0xf7, 0xb8, 0x14, 0xd2, 0x8d, 0x04, 0x8d, 0x3c, 0x47, 0xf5,
+	0x0a, 0xef, 0x9d, 0xeb, 0x88, 0x36, 0x84, 0x2d, 0x42, 0x2e, 0x78, 0x86, 0x7e, 0xa4, 0x95, 0xc0,
+	0xe6, 0x32, 0x63, 0x72, 0x81, 0xca, 0xe0, 0x9d, 0xf1, 0xef, 0xd4, 0x62, 0x92, 0x6f, 0x21, 0xb8,
+	0xc1, 0x7b, 0x3d, 0x0e, 0x5c, 0x4b, 0x7d, 0x3e, 0x6d, 0xe9, 0x4d, 0xaf, 0x55, 0x2a, 0x25, 0xe6,
+	0xaf, 0xf0, 0x9e, 0x3a, 0xa3, 0x83, 0x37, 0x00, 0x0d, 0x67, 0xa7, 0x98, 0xc2, 0x8c, 0x49, 0x86,
+	0xdc, 0x34, 0x11, 0x75, 0xb8, 0x0d, 0x41, 0x8d, 0x61, 0xef, 0xb6, 0xf4, 0xe3, 0x23, 0xaa, 0xe0,
+	0xdb, 0x81, 0xfb, 0xaf, 0xf8, 0xee, 0xdf, 0x01, 0x00, 0xdc, 0x4a, 0xb9, 0x65, 0x69, 0x08, 0x00,
+	0x00,
 }
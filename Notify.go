@@ -0,0 +1,288 @@
+/*
+	Notifies the user of incoming messages and invites through the terminal bell,
+	OSC 9 or OSC 777 escape sequences, or a command such as notify-send
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+//Backends notifications can be sent to
+const (
+	NotifyBell = "bell"
+	NotifyOSC9 = "osc9"
+	NotifyOSC777 = "osc777"
+	NotifyCommand = "command"
+)
+
+//How much of a group's messages notify
+const (
+	NotifyAll = "all"
+	NotifyMentions = "mentions"
+	NotifyOff = "off"
+)
+
+//How often the invites are checked, the server doesn't announce new ones
+var InvitePollInterval = time.Minute
+
+//Shortest time between two notifications for the same group, so a busy group doesn't ring constantly
+var NotifyCooldown = 5 * time.Second
+
+//How long the notify command may run before it is killed
+var NotifyCommandTimeout = 10 * time.Second
+
+//Longest message text put into a notification
+const notifyBodyLength = 140
+
+var notifyMutex sync.Mutex
+var lastNotified = make(map[string]time.Time)
+//Levels set with ~notify, over the ones from the config, loaded when first needed
+var notifyOverrides map[string]string
+
+func validNotifyLevel(level string) bool {
+	return level == NotifyAll || level == NotifyMentions || level == NotifyOff
+}
+
+//Checks a comma separated list of backends, empty or none turns notifications off
+func parseNotifyBackends(setting string) ([]string, error) {
+	var backends []string
+	for _, backend := range strings.Split(setting, ",") {
+		backend = strings.TrimSpace(backend)
+		switch backend {
+		case "", "none":
+		case NotifyBell, NotifyOSC9, NotifyOSC777, NotifyCommand:
+			backends = append(backends, backend)
+		default:
+			return nil, errors.New("unknown notification backend \"" + backend + "\", expected bell, osc9, osc777, command or none")
+		}
+	}
+	return backends, nil
+}
+
+//Per group notification levels set with ~notify, kept next to the saved sessions
+func notifySettingsPath() string {
+	if config.ProfileDir == "" {
+		return ""
+	}
+	server := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(config.Address)
+	return filepath.Join(config.ProfileDir, server + "-notify.json")
+}
+
+func loadNotifyOverrides() {
+	if notifyOverrides != nil {
+		return
+	}
+	notifyOverrides = make(map[string]string)
+	path := notifySettingsPath()
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Could not load notification settings: ", err)
+		}
+		return
+	}
+	if parseErr := json.Unmarshal(data, &notifyOverrides); parseErr != nil {
+		log.Println("Could not parse notification settings: ", parseErr)
+	}
+}
+
+//Level of the group, from ~notify, then the config's notifyGroups, then its default level
+func groupNotifyLevel(groupName string) string {
+	notifyMutex.Lock()
+	defer notifyMutex.Unlock()
+	loadNotifyOverrides()
+	if level, ok := notifyOverrides[groupName]; ok {
+		return level
+	}
+	if level, ok := config.NotifyGroups[groupName]; ok {
+		return level
+	}
+	return config.NotifyLevel
+}
+
+func setGroupNotifyLevel(groupName string, level string) error {
+	notifyMutex.Lock()
+	defer notifyMutex.Unlock()
+	loadNotifyOverrides()
+	notifyOverrides[groupName] = level
+	path := notifySettingsPath()
+	if path == "" {
+		return nil
+	}
+	if dirErr := os.MkdirAll(filepath.Dir(path), 0700); dirErr != nil {
+		return dirErr
+	}
+	data, err := json.Marshal(notifyOverrides)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+//Whether text names the user as a whole word, with or without a leading @
+func mentions(text string, username string) bool {
+	if username == "" {
+		return false
+	}
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.'
+	})
+	for _, word := range words {
+		if strings.EqualFold(strings.TrimRight(word, "."), username) {
+			return true
+		}
+	}
+	return false
+}
+
+//Text safe to put inside an escape sequence or a command argument
+func notifyText(text string, limit int) string {
+	text = strings.Join(strings.Fields(sanitizeText(text)), " ")
+	if runes := []rune(text); limit > 0 && len(runes) > limit {
+		text = string(runes[:limit - 1]) + "…"
+	}
+	return text
+}
+
+//Text for one field of an OSC sequence, semicolons would start another field
+//and BEL, ESC or ST would end the sequence early
+func oscField(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ';':
+			return ','
+		case '\a', 0x1b, 0x9c:
+			return -1
+		}
+		return r
+	}, text)
+}
+
+//Notifies of a live message from someone else when the group's level asks for it
+func notifyMessage(groupName string, username string, text string) {
+	ownName := client.SessionToken().Username
+	if username == ownName {
+		return
+	}
+	level := groupNotifyLevel(groupName)
+	mentioned := mentions(text, ownName)
+	if level == NotifyOff || (level == NotifyMentions && !mentioned) {
+		return
+	}
+	title := username + " in " + groupName
+	if mentioned {
+		title = username + " mentioned you in " + groupName
+	}
+	notify(groupName, title, text)
+}
+
+//Sends the notification to every configured backend, unless the key was notified moments ago
+func notify(key string, title string, body string) {
+	notifyMutex.Lock()
+	if last, ok := lastNotified[key]; ok && time.Since(last) < NotifyCooldown {
+		notifyMutex.Unlock()
+		return
+	}
+	lastNotified[key] = time.Now()
+	notifyMutex.Unlock()
+
+	title = notifyText(title, 0)
+	body = notifyText(body, notifyBodyLength)
+	backends, _ := parseNotifyBackends(config.Notify)
+	for _, backend := range backends {
+		switch backend {
+		case NotifyBell:
+			ui.Alert("\a")
+		case NotifyOSC9:
+			ui.Alert("\x1b]9;" + oscField(title) + ": " + oscField(body) + "\a")
+		case NotifyOSC777:
+			ui.Alert("\x1b]777;notify;" + oscField(title) + ";" + oscField(body) + "\a")
+		case NotifyCommand:
+			go runNotifyCommand(title, body)
+		}
+	}
+}
+
+//Runs the notify command without a shell, {title} and {body} in its arguments are replaced
+//and both are appended when neither appears
+func runNotifyCommand(title string, body string) {
+	args := strings.Fields(config.NotifyCommand)
+	if len(args) == 0 {
+		return
+	}
+	substituted := false
+	placeholders := strings.NewReplacer("{title}", title, "{body}", body)
+	for i, arg := range args[1:] {
+		if strings.Contains(arg, "{title}") || strings.Contains(arg, "{body}") {
+			substituted = true
+			args[i + 1] = placeholders.Replace(arg)
+		}
+	}
+	if !substituted {
+		args = append(args, title, body)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), NotifyCommandTimeout)
+	defer cancel()
+	if err := exec.CommandContext(ctx, args[0], args[1:]...).Run(); err != nil {
+		log.Println("Notify command failed: ", err)
+	}
+}
+
+//Checks for invites until the returned stop func is called, notifying of each one not seen before
+func startInviteWatch() func() {
+	stop := make(chan struct{})
+	go watchInvites(stop)
+	return func() {
+		close(stop)
+	}
+}
+
+func watchInvites(stop chan struct{}) {
+	//Nil until the first check, whose invites the user already knows about from before
+	var seen map[string]bool
+	for {
+		ctx, cancel := requestContext()
+		invites, err := client.Invites(ctx)
+		cancel()
+		if err == nil {
+			if seen == nil {
+				seen = make(map[string]bool)
+				if len(invites) > 0 {
+					ui.Println("*** You have invitations waiting, open View Invitations to answer them")
+				}
+			} else {
+				for _, invite := range invites {
+					if seen[invite.InviteID] {
+						continue
+					}
+					ui.Println("*** " + sanitizeText(invite.FromUsername) + " invited you to " + sanitizeText(invite.GroupName))
+					notify("invite " + invite.InviteID, "initchat invitation", invite.FromUsername + " invited you to " + invite.GroupName)
+				}
+			}
+			for _, invite := range invites {
+				seen[invite.InviteID] = true
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(InvitePollInterval):
+		}
+	}
+}
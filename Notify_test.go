@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOSCField(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "bob in team", "bob in team"},
+		{"semicolons", "a;b;c", "a,b,c"},
+		{"BEL", "ends\ahere", "endshere"},
+		{"ESC backslash", "ends\x1b\\here", "ends\\here"},
+		{"C1 ST", "ends\u009chere", "endshere"},
+		{"new sequence", "\x1b]777;notify;fake;text\a", "]777,notify,fake,text"},
+	}
+	for _, test := range tests {
+		if got := oscField(test.text); got != test.want {
+			t.Errorf("%s: oscField(%q) = %q, want %q", test.name, test.text, got, test.want)
+		}
+	}
+}
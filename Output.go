@@ -0,0 +1,114 @@
+/*
+	Structured output of the commands so they can be piped into jq and other tools
+ */
+
+package main
+
+import (
+	"./Messages"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+//Formats the commands can print in, ndjson writes one object per line
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+	OutputNDJSON = "ndjson"
+)
+
+var outputFormat = OutputText
+
+func validOutput(format string) bool {
+	return format == OutputText || format == OutputJSON || format == OutputNDJSON
+}
+
+type groupJSON struct {
+	Index int `json:"index"`
+	Name string `json:"name"`
+}
+
+type inviteJSON struct {
+	Index int `json:"index"`
+	InviteID string `json:"inviteID"`
+	FromUsername string `json:"fromUsername"`
+	GroupName string `json:"groupName"`
+}
+
+type userJSON struct {
+	Index int `json:"index"`
+	Username string `json:"username"`
+}
+
+type messageJSON struct {
+	Group string `json:"group,omitempty"`
+	Username string `json:"username"`
+	Message string `json:"message"`
+	Time string `json:"time"`
+	Timestamp uint64 `json:"timestamp"`
+}
+
+type fileJSON struct {
+	FileID string `json:"fileID"`
+	Path string `json:"path,omitempty"`
+}
+
+//Indexes match the numbers commands like invites accept take
+func groupsJSON(groupNames []string) []interface{} {
+	items := make([]interface{}, 0, len(groupNames))
+	for i, groupName := range groupNames {
+		items = append(items, groupJSON{Index: i, Name: groupName})
+	}
+	return items
+}
+
+func invitesJSON(invites []*Messages.InvitesResp_Invite) []interface{} {
+	items := make([]interface{}, 0, len(invites))
+	for i, invite := range invites {
+		items = append(items, inviteJSON{
+			Index: i,
+			InviteID: invite.InviteID,
+			FromUsername: invite.FromUsername,
+			GroupName: invite.GroupName,
+		})
+	}
+	return items
+}
+
+func usersJSON(usernames []string) []interface{} {
+	items := make([]interface{}, 0, len(usernames))
+	for i, username := range usernames {
+		items = append(items, userJSON{Index: i, Username: username})
+	}
+	return items
+}
+
+func textMessageJSON(textMsg *Messages.TextMessage) messageJSON {
+	return messageJSON{
+		Username: textMsg.Username,
+		Message: textMsg.Message,
+		Time: time.Unix(int64(textMsg.Time), 0).UTC().Format(time.RFC3339),
+		Timestamp: textMsg.Time,
+	}
+}
+
+//Writes value as a single line of JSON
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(value)
+}
+
+//Prints a listing as one JSON array, or one object per line for ndjson
+func printList(items []interface{}) error {
+	if outputFormat != OutputNDJSON {
+		return printJSON(items)
+	}
+	for _, item := range items {
+		if err := printJSON(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+	Saves the session token per server so later runs can resume without the password
+ */
+
+package main
+
+import (
+	"./initchat"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//Renew or warn this long before the token expires
+var SessionRenewMargin = 5 * time.Minute
+
+//One profile per server, named after its address
+func profilePath() string {
+	if config.ProfileDir == "" {
+		return ""
+	}
+	name := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(config.Address)
+	return filepath.Join(config.ProfileDir, name + ".json")
+}
+
+//Returns nil when there is no saved session or it has already expired
+func loadSavedSession() *initchat.SessionToken {
+	path := profilePath()
+	if path == "" {
+		return nil
+	}
+	data, fErr := ioutil.ReadFile(path)
+	if fErr != nil {
+		return nil
+	}
+	token := initchat.SessionToken{}
+	if parseErr := json.Unmarshal(data, &token); parseErr != nil {
+		return nil
+	}
+	if token.Token == "" || token.Expired() {
+		return nil
+	}
+	return &token
+}
+
+//Writes the token readable only by the current user
+func saveSession(token initchat.SessionToken) error {
+	path := profilePath()
+	if path == "" || token.Token == "" {
+		return nil
+	}
+	if dirErr := os.MkdirAll(filepath.Dir(path), 0700); dirErr != nil {
+		return dirErr
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if writeErr := ioutil.WriteFile(tmpPath, data, 0600); writeErr != nil {
+		return writeErr
+	}
+	//WriteFile keeps the mode of an existing file, so enforce it
+	if chmodErr := os.Chmod(tmpPath, 0600); chmodErr != nil {
+		return chmodErr
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func forgetSession() {
+	if path := profilePath(); path != "" {
+		os.Remove(path)
+	}
+}
+
+//Saves the fresh token and keeps it renewed until the returned stop func is called
+func startSessionWatch() func() {
+	if saveErr := saveSession(client.SessionToken()); saveErr != nil {
+		log.Println("Could not save session: ", saveErr)
+	}
+	stop := make(chan struct{})
+	go watchSessionExpiry(stop)
+	return func() {
+		close(stop)
+	}
+}
+
+//Logs in again shortly before the token expires, or tells the user to when neither the password nor a certificate can
+func watchSessionExpiry(stop chan struct{}) {
+	for {
+		token := client.SessionToken()
+		if token.ExpireTime == 0 {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Until(token.Expires().Add(-SessionRenewMargin))):
+		}
+		ctx, cancel := requestContext()
+		renewErr := client.RenewSession(ctx)
+		if renewErr != nil && clientCert != nil {
+			//A session resumed from disk doesn't know it can log in with the certificate
+			renewErr = client.LoginWithCertificate(ctx, token.Username)
+		}
+		cancel()
+		renewed := client.SessionToken()
+		if renewErr != nil || renewed.ExpireTime <= token.ExpireTime {
+			ui.Println("*** Your session expires at " + renderer.Clock(token.Expires()) +
+				", sign out and log in again to stay connected")
+			return
+		}
+		if saveErr := saveSession(renewed); saveErr != nil {
+			log.Println("Could not save session: ", saveErr)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/*
+	Renders messages the same way for history, live messages and search results,
+	with date separators and the clock and time zone the user picked
+ */
+
+package main
+
+import (
+	"./Messages"
+	"./initchat"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Clock formats and timestamp styles the config accepts
+const (
+	Clock12 = "12h"
+	Clock24 = "24h"
+	TimestampsAbsolute = "absolute"
+	TimestampsRelative = "relative"
+)
+
+var clockLayouts = map[string]string{
+	Clock12: "3:04PM",
+	Clock24: "15:04",
+}
+
+//Renderer set up from the config, used by everything that prints a message
+var renderer = newMessageRenderer(defaultConfig())
+
+type MessageRenderer struct {
+	mutex sync.Mutex
+	location *time.Location
+	clockLayout string
+	relative bool
+	//Day of the last message rendered, a separator is printed when the next one is on another day
+	lastDay string
+	now func() time.Time
+}
+
+func newMessageRenderer(cfg *Config) *MessageRenderer {
+	location, err := time.LoadLocation(cfg.TimeZone)
+	if err != nil {
+		location = time.Local
+	}
+	clockLayout, ok := clockLayouts[cfg.Clock]
+	if !ok {
+		clockLayout = clockLayouts[Clock12]
+	}
+	return &MessageRenderer{
+		location: location,
+		clockLayout: clockLayout,
+		relative: cfg.Timestamps == TimestampsRelative,
+		now: time.Now,
+	}
+}
+
+//Starts a new view so its first message gets a date separator
+func (r *MessageRenderer) Reset() {
+	r.mutex.Lock()
+	r.lastDay = ""
+	r.mutex.Unlock()
+}
+
+//Time of day in the chosen clock and time zone
+func (r *MessageRenderer) Clock(t time.Time) string {
+	return t.In(r.location).Format(r.clockLayout)
+}
+
+//Date and time, for lines shown without a separator above them
+func (r *MessageRenderer) Stamp(t time.Time) string {
+	return t.In(r.location).Format("2006-01-02") + " " + r.Clock(t)
+}
+
+func (r *MessageRenderer) day(t time.Time) string {
+	return t.In(r.location).Format("2006-01-02")
+}
+
+//Line such as "— Tuesday, Oct 14 —" above the first message of a day
+func (r *MessageRenderer) Separator(t time.Time) string {
+	t = t.In(r.location)
+	now := r.now().In(r.location)
+	label := t.Format("Monday, Jan 2")
+	if t.Year() != now.Year() {
+		label += t.Format(", 2006")
+	}
+	if r.relative {
+		switch r.day(t) {
+		case r.day(now):
+			label = "Today"
+		case r.day(now.AddDate(0, 0, -1)):
+			label = "Yesterday"
+		}
+	}
+	return "— " + label + " —"
+}
+
+//Relative times only for the last few hours, older messages sit under their day's separator
+func (r *MessageRenderer) messageTime(t time.Time) string {
+	if !r.relative {
+		return r.Clock(t)
+	}
+	age := r.now().Sub(t)
+	switch {
+	case age < 0:
+		return r.Clock(t)
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return strconv.Itoa(int(age / time.Minute)) + "m ago"
+	case age < 6 * time.Hour:
+		return strconv.Itoa(int(age / time.Hour)) + "h ago"
+	}
+	return r.Clock(t)
+}
+
+func messageText(textMsg *Messages.TextMessage) string {
+	if initchat.IsSealed(textMsg.Message) {
+		return "[encrypted message that could not be decrypted]"
+	}
+	return sanitizeText(textMsg.Message)
+}
+
+//The message on one line, status tells how it was protected, such as e2e, and is left out when empty
+func (r *MessageRenderer) Line(textMsg *Messages.TextMessage, status string) string {
+	line := "[" + r.messageTime(time.Unix(int64(textMsg.Time), 0)) + "] " + sanitizeText(textMsg.Username) + " >> " + messageText(textMsg)
+	if status != "" {
+		line += "  [" + status + "]"
+	}
+	return line
+}
+
+//The message's line, preceded by a date separator when it starts a new day
+func (r *MessageRenderer) Render(textMsg *Messages.TextMessage, status string) []string {
+	sent := time.Unix(int64(textMsg.Time), 0)
+	var lines []string
+	r.mutex.Lock()
+	if day := r.day(sent); day != r.lastDay {
+		r.lastDay = day
+		lines = append(lines, r.Separator(sent))
+	}
+	r.mutex.Unlock()
+	return append(lines, r.Line(textMsg, status))
+}
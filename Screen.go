@@ -0,0 +1,524 @@
+/*
+	Full screen terminal UI with a scrollable message pane, a status bar and an editable input line
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Lines kept for scrolling back, older ones are dropped
+var MaxScrollback = 5000
+
+//Inputs kept for recalling with the up arrow
+var MaxHistory = 100
+
+//Keys buffered while nothing reads a line, such as during a transfer
+var MaxTypeAhead = 256
+
+const inputPrompt = "> "
+
+//Keys that aren't plain runes, read from escape sequences
+const (
+	keyNone rune = -(iota + 1)
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyPageUp
+	keyPageDown
+)
+
+type screenUI struct {
+	mutex sync.Mutex
+	in *bufio.Reader
+	out *bufio.Writer
+	oldState *term.State
+	closeOnce sync.Once
+	stop chan struct{}
+	width int
+	height int
+	lines []string
+	//Rows scrolled up from the newest message
+	scroll int
+	status string
+	progress string
+	input []rune
+	cursor int
+	history []string
+	historyI int
+	//What was being typed before browsing history
+	draft []rune
+	//Keys read ahead by readKeys, so Ctrl-C is seen even while no line is being read
+	keys chan keyPress
+	//Called instead of ending input when Ctrl-C is pressed, while set
+	onInterrupt func()
+}
+
+type keyPress struct {
+	key rune
+	err error
+}
+
+//Switches the terminal to raw mode and the alternate screen
+func newScreenUI() (*screenUI, error) {
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	screen := &screenUI{
+		in: bufio.NewReader(os.Stdin),
+		out: bufio.NewWriter(os.Stdout),
+		oldState: oldState,
+		stop: make(chan struct{}),
+		keys: make(chan keyPress, MaxTypeAhead),
+	}
+	screen.width, screen.height = terminalSize()
+	//Log output would otherwise be drawn over the screen
+	log.SetOutput(screen)
+	screen.mutex.Lock()
+	screen.out.WriteString("\x1b[?1049h")
+	screen.render()
+	screen.mutex.Unlock()
+	go screen.watchSize()
+	go screen.readKeys()
+	return screen, nil
+}
+
+func terminalSize() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+//Redraws when the terminal is resized, polled since SIGWINCH doesn't exist everywhere
+func (screen *screenUI) watchSize() {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-screen.stop:
+			return
+		case <-ticker.C:
+		}
+		width, height := terminalSize()
+		screen.mutex.Lock()
+		if width != screen.width || height != screen.height {
+			screen.width, screen.height = width, height
+			screen.render()
+		}
+		screen.mutex.Unlock()
+	}
+}
+
+func (screen *screenUI) Println(args ...interface{}) {
+	text := strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+	screen.mutex.Lock()
+	defer screen.mutex.Unlock()
+	for _, line := range strings.Split(text, "\n") {
+		line = stripControls(line)
+		//Keep the same rows in view while scrolled back
+		if screen.scroll > 0 {
+			screen.scroll += len(wrapLine(line, screen.width))
+		}
+		screen.lines = append(screen.lines, line)
+	}
+	if len(screen.lines) > MaxScrollback {
+		screen.lines = screen.lines[len(screen.lines) - MaxScrollback:]
+	}
+	screen.render()
+}
+
+//Lets the screen be used as the log output
+func (screen *screenUI) Write(p []byte) (int, error) {
+	screen.Println(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+func (screen *screenUI) SetStatus(status string) {
+	screen.mutex.Lock()
+	defer screen.mutex.Unlock()
+	screen.status = status
+	screen.render()
+}
+
+func (screen *screenUI) Progress(text string) {
+	screen.mutex.Lock()
+	defer screen.mutex.Unlock()
+	screen.progress = text
+	screen.render()
+}
+
+func (screen *screenUI) Alert(sequence string) {
+	screen.mutex.Lock()
+	defer screen.mutex.Unlock()
+	screen.out.WriteString(sequence)
+	screen.out.Flush()
+}
+
+func (screen *screenUI) Clear() {
+	screen.mutex.Lock()
+	defer screen.mutex.Unlock()
+	screen.lines = nil
+	screen.scroll = 0
+	screen.render()
+}
+
+func (screen *screenUI) Close() {
+	screen.closeOnce.Do(func() {
+		close(screen.stop)
+		log.SetOutput(os.Stderr)
+		screen.mutex.Lock()
+		screen.out.WriteString("\x1b[?1049l")
+		screen.out.Flush()
+		screen.mutex.Unlock()
+		term.Restore(int(os.Stdin.Fd()), screen.oldState)
+	})
+}
+
+//Edits the input line until enter, Ctrl-C or Ctrl-D on an empty line end input with io.EOF
+func (screen *screenUI) ReadLine() (string, error) {
+	for {
+		press := <-screen.keys
+		if press.err != nil {
+			return "", press.err
+		}
+		screen.mutex.Lock()
+		line, done, eof := screen.handleKey(press.key)
+		screen.render()
+		screen.mutex.Unlock()
+		if eof {
+			return "", io.EOF
+		}
+		if done {
+			return line, nil
+		}
+	}
+}
+
+//Passes keys on to ReadLine, except Ctrl-C while an interrupt handler is set
+func (screen *screenUI) readKeys() {
+	for {
+		key, err := screen.readKey()
+		if err == nil && key == 0x03 {
+			screen.mutex.Lock()
+			interrupt := screen.onInterrupt
+			screen.mutex.Unlock()
+			if interrupt != nil {
+				interrupt()
+				continue
+			}
+		}
+		screen.keys <- keyPress{key: key, err: err}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (screen *screenUI) OnInterrupt(interrupt func()) func() {
+	screen.mutex.Lock()
+	defer screen.mutex.Unlock()
+	screen.onInterrupt = interrupt
+	return func() {
+		screen.mutex.Lock()
+		defer screen.mutex.Unlock()
+		screen.onInterrupt = nil
+	}
+}
+
+//Reads one rune, decoding the escape sequences for arrows and paging keys
+func (screen *screenUI) readKey() (rune, error) {
+	r, _, err := screen.in.ReadRune()
+	if err != nil {
+		return keyNone, err
+	}
+	//A lone escape press has nothing buffered after it
+	if r != 0x1b || screen.in.Buffered() == 0 {
+		return r, nil
+	}
+	introducer, _ := screen.in.ReadByte()
+	if introducer != '[' && introducer != 'O' {
+		return keyNone, nil
+	}
+	params := ""
+	for {
+		b, readErr := screen.in.ReadByte()
+		if readErr != nil {
+			return keyNone, readErr
+		}
+		if b >= 0x40 && b <= 0x7e {
+			switch b {
+			case 'A':
+				return keyUp, nil
+			case 'B':
+				return keyDown, nil
+			case 'C':
+				return keyRight, nil
+			case 'D':
+				return keyLeft, nil
+			case 'H':
+				return keyHome, nil
+			case 'F':
+				return keyEnd, nil
+			case '~':
+				switch params {
+				case "1", "7":
+					return keyHome, nil
+				case "4", "8":
+					return keyEnd, nil
+				case "3":
+					return keyDelete, nil
+				case "5":
+					return keyPageUp, nil
+				case "6":
+					return keyPageDown, nil
+				}
+			}
+			return keyNone, nil
+		}
+		params += string(b)
+	}
+}
+
+//Applies a key to the input line, returning the line once enter is pressed
+func (screen *screenUI) handleKey(key rune) (string, bool, bool) {
+	switch key {
+	case '\r', '\n':
+		line := string(screen.input)
+		if line != "" && (len(screen.history) == 0 || screen.history[len(screen.history) - 1] != line) {
+			screen.history = append(screen.history, line)
+			if len(screen.history) > MaxHistory {
+				screen.history = screen.history[1:]
+			}
+		}
+		screen.historyI = len(screen.history)
+		screen.draft = nil
+		screen.input = nil
+		screen.cursor = 0
+		screen.scroll = 0
+		return line, true, false
+	case 0x03: //Ctrl-C
+		return "", false, true
+	case 0x04: //Ctrl-D
+		if len(screen.input) == 0 {
+			return "", false, true
+		}
+		screen.deleteAt(screen.cursor)
+	case 0x7f, 0x08: //Backspace
+		if screen.cursor > 0 {
+			screen.cursor--
+			screen.deleteAt(screen.cursor)
+		}
+	case keyDelete:
+		screen.deleteAt(screen.cursor)
+	case keyLeft:
+		if screen.cursor > 0 {
+			screen.cursor--
+		}
+	case keyRight:
+		if screen.cursor < len(screen.input) {
+			screen.cursor++
+		}
+	case keyHome, 0x01: //Ctrl-A
+		screen.cursor = 0
+	case keyEnd, 0x05: //Ctrl-E
+		screen.cursor = len(screen.input)
+	case 0x15: //Ctrl-U
+		screen.input = append([]rune{}, screen.input[screen.cursor:]...)
+		screen.cursor = 0
+	case 0x0b: //Ctrl-K
+		screen.input = screen.input[:screen.cursor]
+	case 0x17: //Ctrl-W
+		start := screen.cursor
+		for start > 0 && screen.input[start - 1] == ' ' {
+			start--
+		}
+		for start > 0 && screen.input[start - 1] != ' ' {
+			start--
+		}
+		screen.input = append(screen.input[:start], screen.input[screen.cursor:]...)
+		screen.cursor = start
+	case keyUp:
+		if screen.historyI > 0 {
+			if screen.historyI == len(screen.history) {
+				screen.draft = screen.input
+			}
+			screen.historyI--
+			screen.input = []rune(screen.history[screen.historyI])
+			screen.cursor = len(screen.input)
+		}
+	case keyDown:
+		if screen.historyI < len(screen.history) {
+			screen.historyI++
+			if screen.historyI == len(screen.history) {
+				screen.input = screen.draft
+			} else {
+				screen.input = []rune(screen.history[screen.historyI])
+			}
+			screen.cursor = len(screen.input)
+		}
+	case keyPageUp:
+		screen.scroll += screen.paneHeight() / 2
+	case keyPageDown:
+		screen.scroll -= screen.paneHeight() / 2
+		if screen.scroll < 0 {
+			screen.scroll = 0
+		}
+	default:
+		if key >= 0x20 && key != 0x7f {
+			screen.input = append(screen.input[:screen.cursor], append([]rune{key}, screen.input[screen.cursor:]...)...)
+			screen.cursor++
+		}
+	}
+	return "", false, false
+}
+
+func (screen *screenUI) deleteAt(i int) {
+	if i < len(screen.input) {
+		screen.input = append(screen.input[:i], screen.input[i + 1:]...)
+	}
+}
+
+//Rows left for messages after the status bar and input line
+func (screen *screenUI) paneHeight() int {
+	if screen.height < 3 {
+		return 1
+	}
+	return screen.height - 2
+}
+
+//Redraws the whole screen, the caller must hold the mutex
+func (screen *screenUI) render() {
+	paneHeight := screen.paneHeight()
+	//Only wrap as many of the newest lines as can be shown
+	var rows []string
+	for i := len(screen.lines) - 1; i >= 0 && len(rows) < paneHeight + screen.scroll; i-- {
+		rows = append(wrapLine(screen.lines[i], screen.width), rows...)
+	}
+	maxScroll := len(rows) - paneHeight
+	if maxScroll < 0 {
+		maxScroll = 0
+	}
+	if screen.scroll > maxScroll {
+		screen.scroll = maxScroll
+	}
+	end := len(rows) - screen.scroll
+	start := end - paneHeight
+	if start < 0 {
+		start = 0
+	}
+
+	out := screen.out
+	out.WriteString("\x1b[?25l\x1b[H")
+	for row := 0; row < paneHeight; row++ {
+		out.WriteString("\x1b[2K")
+		if start + row < end {
+			out.WriteString(rows[start + row])
+		}
+		out.WriteString("\x1b[0m\r\n")
+	}
+
+	statusParts := []string{screen.status}
+	if screen.progress != "" {
+		statusParts = append(statusParts, screen.progress)
+	}
+	if screen.scroll > 0 {
+		statusParts = append(statusParts, "scrolled back " + strconv.Itoa(screen.scroll) + " lines, PgDn to return")
+	}
+	status := []rune(" " + strings.Join(statusParts, " | "))
+	if len(status) > screen.width {
+		status = status[:screen.width]
+	}
+	out.WriteString("\x1b[2K\x1b[7m" + string(status) + strings.Repeat(" ", screen.width - len(status)) + "\x1b[0m\r\n")
+
+	//Scroll the input sideways so the cursor stays visible
+	available := screen.width - len(inputPrompt) - 1
+	if available < 1 {
+		available = 1
+	}
+	inputStart := 0
+	if screen.cursor > available {
+		inputStart = screen.cursor - available
+	}
+	inputEnd := inputStart + available
+	if inputEnd > len(screen.input) {
+		inputEnd = len(screen.input)
+	}
+	out.WriteString("\x1b[2K" + inputPrompt + string(screen.input[inputStart:inputEnd]))
+	fmt.Fprintf(out, "\x1b[%d;%dH\x1b[?25h", screen.height, len(inputPrompt) + screen.cursor - inputStart + 1)
+	out.Flush()
+}
+
+//Length of the color sequence starting at runes[i], 0 when there isn't one
+func sgrLength(runes []rune, i int) int {
+	if runes[i] != 0x1b || i + 1 >= len(runes) || runes[i + 1] != '[' {
+		return 0
+	}
+	for end := i + 2; end < len(runes); end++ {
+		if runes[end] == 'm' {
+			return end - i + 1
+		}
+		if runes[end] != ';' && (runes[end] < '0' || runes[end] > '9') {
+			return 0
+		}
+	}
+	return 0
+}
+
+//Keeps color sequences but drops every other control character so a line can't move the cursor
+func stripControls(line string) string {
+	line = strings.Replace(line, "\t", "    ", -1)
+	runes := []rune(line)
+	var out []rune
+	for i := 0; i < len(runes); {
+		if n := sgrLength(runes, i); n > 0 {
+			out = append(out, runes[i:i + n]...)
+			i += n
+			continue
+		}
+		if !isControl(runes[i]) {
+			out = append(out, runes[i])
+		}
+		i++
+	}
+	return string(out)
+}
+
+//Splits a line into rows no wider than the terminal, color sequences take no width
+func wrapLine(line string, width int) []string {
+	runes := []rune(line)
+	if width < 1 || len(runes) <= width {
+		return []string{line}
+	}
+	var rows []string
+	rowStart, visible := 0, 0
+	for i := 0; i < len(runes); {
+		if n := sgrLength(runes, i); n > 0 {
+			i += n
+			continue
+		}
+		if visible == width {
+			rows = append(rows, string(runes[rowStart:i]))
+			rowStart, visible = i, 0
+		}
+		visible++
+		i++
+	}
+	return append(rows, string(runes[rowStart:]))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+//Screen reading keys from input instead of the terminal
+func newTestScreen(input string) *screenUI {
+	return &screenUI{
+		in: bufio.NewReader(strings.NewReader(input)),
+		out: bufio.NewWriter(ioutil.Discard),
+		stop: make(chan struct{}),
+		keys: make(chan keyPress, MaxTypeAhead),
+		width: 80,
+		height: 24,
+	}
+}
+
+func TestScreenInterrupt(t *testing.T) {
+	screen := newTestScreen("\x03hi\r")
+	interrupted := make(chan struct{})
+	stopInterrupt := screen.OnInterrupt(func() {
+		close(interrupted)
+	})
+	go screen.readKeys()
+	select {
+	case <-interrupted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ctrl-C did not call the interrupt handler")
+	}
+	stopInterrupt()
+	if line, err := screen.ReadLine(); err != nil || line != "hi" {
+		t.Errorf("ReadLine() after an interrupt = %q, %v, want the line typed after it", line, err)
+	}
+}
+
+func TestScreenCtrlCEndsInput(t *testing.T) {
+	screen := newTestScreen("\x03")
+	go screen.readKeys()
+	if _, err := screen.ReadLine(); err != io.EOF {
+		t.Errorf("ReadLine() with Ctrl-C and no interrupt handler = %v, want io.EOF", err)
+	}
+}
+
+func TestStripControls(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"color kept", "\x1b[31mred\x1b[0m", "\x1b[31mred\x1b[0m"},
+		{"cursor movement", "\x1b[2Jgone", "[2Jgone"},
+		{"carriage return", "a\rb", "ab"},
+		{"C1 CSI", "a\u009b2Jb", "a2Jb"},
+		{"C1 range ends", "\u0080a\u009f", "a"},
+		{"printable after C1", " é", " é"},
+	}
+	for _, test := range tests {
+		if got := stripControls(test.line); got != test.want {
+			t.Errorf("%s: stripControls(%q) = %q, want %q", test.name, test.line, got, test.want)
+		}
+	}
+}
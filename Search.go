@@ -0,0 +1,195 @@
+/*
+	Full-text search over the local message history
+ */
+
+package main
+
+import (
+	"./Messages"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//Terms a message must all contain, each matching the start of a word, and the filters it must pass
+type SearchQuery struct {
+	Terms []string
+	User string
+	Group string
+	Since time.Time
+	Until time.Time
+}
+
+type SearchHit struct {
+	Group string
+	Message *Messages.TextMessage
+}
+
+const searchSyntax = "words to find, with optional from:USER group:GROUP since:TIME until:TIME " +
+	"where TIME is 2006-01-02, RFC 3339 or an age like 3h or 7d"
+
+//Splits text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+//Parses words and from:, group:, since: and until: filters
+func parseSearch(input string) (*SearchQuery, error) {
+	query := &SearchQuery{}
+	for _, field := range strings.Fields(input) {
+		colon := strings.Index(field, ":")
+		if colon > 0 {
+			name, value := field[:colon], field[colon + 1:]
+			switch name {
+			case "from":
+				query.User = value
+				continue
+			case "group":
+				query.Group = value
+				continue
+			case "since", "until":
+				t, isDate, err := parseSearchTime(value)
+				if err != nil {
+					return nil, err
+				}
+				if name == "since" {
+					query.Since = t
+				} else if isDate {
+					//A date covers the whole day, up to the start of the next one
+					query.Until = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+				} else {
+					query.Until = t
+				}
+				continue
+			}
+		}
+		query.Terms = append(query.Terms, tokenize(field)...)
+	}
+	if len(query.Terms) == 0 && query.User == "" && query.Group == "" && query.Since.IsZero() && query.Until.IsZero() {
+		return nil, errors.New("nothing to search for, expected " + searchSyntax)
+	}
+	return query, nil
+}
+
+//Accepts a date, an RFC 3339 time or an age counted back from now, and tells whether it was a date,
+//which is returned as the start of that day
+func parseSearchTime(value string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+			return time.Now().AddDate(0, 0, -days), false, nil
+		}
+	}
+	if age, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-age), false, nil
+	}
+	return time.Time{}, false, errors.New("invalid time \"" + value + "\", expected 2006-01-02, RFC 3339 or an age like 3h or 7d")
+}
+
+func (query *SearchQuery) matches(textMsg *Messages.TextMessage) bool {
+	if query.User != "" && !strings.EqualFold(textMsg.Username, query.User) {
+		return false
+	}
+	sent := time.Unix(int64(textMsg.Time), 0)
+	if !query.Since.IsZero() && sent.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && sent.After(query.Until) {
+		return false
+	}
+	words := tokenize(textMsg.Message)
+	for _, term := range query.Terms {
+		found := false
+		for _, word := range words {
+			if strings.HasPrefix(word, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//Finds matching messages in the query's group or every stored group, oldest first per group
+func (hist *History) Search(query *SearchQuery) ([]SearchHit, error) {
+	groupNames := []string{query.Group}
+	if query.Group == "" {
+		groupNames = hist.Groups()
+	}
+	var hits []SearchHit
+	for _, groupName := range groupNames {
+		textMsgs, err := hist.Messages(groupName)
+		if err != nil {
+			return hits, err
+		}
+		for _, textMsg := range textMsgs {
+			if query.matches(textMsg) {
+				hits = append(hits, SearchHit{Group: groupName, Message: textMsg})
+			}
+		}
+	}
+	return hits, nil
+}
+
+//Wraps the words that matched a term in on and off, usually terminal color codes
+func highlightTerms(text string, terms []string, on string, off string) string {
+	if len(terms) == 0 || on == "" {
+		return text
+	}
+	var out strings.Builder
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			out.WriteRune(runes[i])
+			i++
+			continue
+		}
+		end := i
+		for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) {
+			end++
+		}
+		word := string(runes[i:end])
+		lower := strings.ToLower(word)
+		hit := false
+		for _, term := range terms {
+			if strings.HasPrefix(lower, term) {
+				hit = true
+				break
+			}
+		}
+		if hit {
+			out.WriteString(on + word + off)
+		} else {
+			out.WriteString(word)
+		}
+		i = end
+	}
+	return out.String()
+}
+
+const (
+	highlightOn = "\x1b[1;33m"
+	highlightOff = "\x1b[0m"
+)
+
+//One line per hit with the group, sender and matched words highlighted when color is wanted
+func formatSearchHit(hit SearchHit, terms []string, color bool) string {
+	t := time.Unix(int64(hit.Message.Time), 0)
+	text := messageText(hit.Message)
+	if color {
+		text = highlightTerms(text, terms, highlightOn, highlightOff)
+	}
+	return "[" + renderer.Stamp(t) + "] #" + sanitizeText(hit.Group) + " " + sanitizeText(hit.Message.Username) + " >> " + text
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"./Messages"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearch(t *testing.T) {
+	march5 := time.Date(2024, 3, 5, 0, 0, 0, 0, time.Local)
+	endOfMarch5 := time.Date(2024, 3, 6, 0, 0, 0, 0, time.Local).Add(-time.Nanosecond)
+	noon := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		input string
+		want SearchQuery
+		wantErr bool
+	}{
+		{"Hello, World", SearchQuery{Terms: []string{"hello", "world"}}, false},
+		{"from:bob lunch", SearchQuery{Terms: []string{"lunch"}, User: "bob"}, false},
+		{"group:team", SearchQuery{Group: "team"}, false},
+		{"since:2024-03-05", SearchQuery{Since: march5}, false},
+		{"until:2024-03-05", SearchQuery{Until: endOfMarch5}, false},
+		{"until:2024-03-05T12:00:00Z", SearchQuery{Until: noon}, false},
+		{"since:2024-03-05 until:2024-03-05 plans", SearchQuery{Terms: []string{"plans"}, Since: march5, Until: endOfMarch5}, false},
+		{"", SearchQuery{}, true},
+		{"   ", SearchQuery{}, true},
+		{"since:yesterday", SearchQuery{}, true},
+	}
+	for _, test := range tests {
+		query, err := parseSearch(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseSearch(%q) = %+v, want an error", test.input, query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSearch(%q) error = %v", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(*query, test.want) {
+			t.Errorf("parseSearch(%q) = %+v, want %+v", test.input, *query, test.want)
+		}
+	}
+}
+
+func TestParseSearchAge(t *testing.T) {
+	query, err := parseSearch("since:7d until:3h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if age := time.Since(query.Since); age < 7 * 24 * time.Hour - time.Hour || age > 7 * 24 * time.Hour + time.Hour {
+		t.Errorf("since:7d = %v ago", age)
+	}
+	if age := time.Since(query.Until); age < 3 * time.Hour || age > 3 * time.Hour + time.Minute {
+		t.Errorf("until:3h = %v ago", age)
+	}
+}
+
+func TestSearchUntilDate(t *testing.T) {
+	afternoon := &Messages.TextMessage{Message: "plans", Time: uint64(time.Date(2024, 3, 5, 15, 0, 0, 0, time.Local).Unix())}
+	nextDay := &Messages.TextMessage{Message: "plans", Time: uint64(time.Date(2024, 3, 6, 0, 0, 0, 0, time.Local).Unix())}
+	query, err := parseSearch("plans until:2024-03-05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !query.matches(afternoon) {
+		t.Error("until:2024-03-05 left out a message sent that afternoon")
+	}
+	if query.matches(nextDay) {
+		t.Error("until:2024-03-05 matched a message sent at midnight after it")
+	}
+}
+
+func TestSearchGroupOnly(t *testing.T) {
+	useHistoryDir(t)
+	hist := testOpenHistory(t, "alice", "secret")
+	hist.Record("team", &Messages.TextMessage{Message: "one", Time: 1})
+	hist.Record("team", &Messages.TextMessage{Message: "two", Time: 2})
+	hist.Record("other", &Messages.TextMessage{Message: "three", Time: 3})
+
+	query, err := parseSearch("group:team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits, err := hist.Search(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, hit := range hits {
+		texts = append(texts, hit.Group + ":" + hit.Message.Message)
+	}
+	if !reflect.DeepEqual(texts, []string{"team:one", "team:two"}) {
+		t.Errorf("Search(group:team) = %v, want every message of the group", texts)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+	Terminal the display flow reads input from and prints to, either full screen or plain lines
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+//Everything the display flow shows goes through a UI so incoming messages can't clobber typed input
+type UI interface {
+	//Adds a line to the message area, safe to call from any goroutine
+	Println(args ...interface{})
+	//Waits for the user to enter a line, without the trailing newline
+	ReadLine() (string, error)
+	//Shows connection state and similar lasting information
+	SetStatus(status string)
+	//Shows transfer progress in place, an empty string ends it
+	Progress(text string)
+	Clear()
+	//Writes an escape sequence such as the bell straight to the terminal
+	Alert(sequence string)
+	//Calls interrupt when Ctrl-C is pressed, until the returned func is called
+	OnInterrupt(interrupt func()) func()
+	//Gives the terminal back in the state it was found
+	Close()
+}
+
+var ui UI
+
+//Picks the full screen UI for real terminals and falls back to plain lines when it can't be used
+func newUI(mode string) UI {
+	if mode == "tui" || (mode == "auto" && canUseScreen()) {
+		screen, err := newScreenUI()
+		if err == nil {
+			return screen
+		}
+		log.Println("Falling back to line mode: ", err)
+	}
+	return newLineUI(os.Stdout)
+}
+
+func canUseScreen() bool {
+	termName := os.Getenv("TERM")
+	if termName == "" || termName == "dumb" {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+//Whether stdout is a terminal that understands color codes
+func colorOutput() bool {
+	if _, isScreen := ui.(*screenUI); isScreen {
+		return true
+	}
+	termName := os.Getenv("TERM")
+	return termName != "" && termName != "dumb" && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+//Expands tabs and drops control characters other than newlines so text from the server
+//can't move the cursor or change colors
+func sanitizeText(text string) string {
+	text = strings.Replace(text, "\t", "    ", -1)
+	return strings.Map(func(r rune) rune {
+		if isControl(r) && r != '\n' {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+//C0 controls, DEL and the C1 controls, which some terminals act on like the escape sequences they stand for
+func isControl(r rune) bool {
+	return r < 0x20 || (r >= 0x7f && r <= 0x9f)
+}
+
+//Line mode for dumb terminals and pipes, prints straight to out
+type lineUI struct {
+	mutex sync.Mutex
+	reader *bufio.Reader
+	out io.Writer
+	inProgress bool
+}
+
+func newLineUI(out io.Writer) *lineUI {
+	return &lineUI{
+		reader: bufio.NewReader(os.Stdin),
+		out: out,
+	}
+}
+
+func (lines *lineUI) Println(args ...interface{}) {
+	lines.mutex.Lock()
+	defer lines.mutex.Unlock()
+	lines.endProgress()
+	fmt.Fprintln(lines.out, args...)
+}
+
+func (lines *lineUI) ReadLine() (string, error) {
+	text, err := lines.reader.ReadString('\n')
+	if err != nil && text == "" {
+		return "", err
+	}
+	return text, nil
+}
+
+func (lines *lineUI) SetStatus(status string) {
+	lines.Println("*** " + status)
+}
+
+func (lines *lineUI) Progress(text string) {
+	lines.mutex.Lock()
+	defer lines.mutex.Unlock()
+	if text == "" {
+		lines.endProgress()
+		return
+	}
+	fmt.Fprint(lines.out, "\r" + text)
+	lines.inProgress = true
+}
+
+//Only written to terminals so piped output stays clean
+func (lines *lineUI) Alert(sequence string) {
+	file, isFile := lines.out.(*os.File)
+	if !isFile || !term.IsTerminal(int(file.Fd())) {
+		return
+	}
+	lines.mutex.Lock()
+	defer lines.mutex.Unlock()
+	fmt.Fprint(lines.out, sequence)
+}
+
+//Catches SIGINT while set, so Ctrl-C cancels instead of killing the process
+func (lines *lineUI) OnInterrupt(interrupt func()) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			interrupt()
+		case <-stop:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(stop)
+	}
+}
+
+//Moves past a progress line so the next output starts on its own line
+func (lines *lineUI) endProgress() {
+	if lines.inProgress {
+		fmt.Fprintln(lines.out)
+		lines.inProgress = false
+	}
+}
+
+//Clears entire terminal
+func (lines *lineUI) Clear() {
+	lines.mutex.Lock()
+	defer lines.mutex.Unlock()
+	if runtime.GOOS == "linux" {
+		cmd := exec.Command("clear") //Command to clear linux terminal
+		cmd.Stdout = os.Stdout
+		cmd.Run()
+	} else if runtime.GOOS == "windows" {
+		cmd := exec.Command("cmd", "/c", "cls") //Clears windows command
+		cmd.Stdout = os.Stdout
+		cmd.Run()
+	} else {
+		println("Could not clear screen")
+	}
+}
+
+func (lines *lineUI) Close() {
+	lines.mutex.Lock()
+	defer lines.mutex.Unlock()
+	lines.endProgress()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSanitizeText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "hello", "hello"},
+		{"newline kept", "one\ntwo", "one\ntwo"},
+		{"tab expanded", "a\tb", "a    b"},
+		{"escape sequence", "\x1b[2Jgone", "[2Jgone"},
+		{"carriage return and bell", "a\rb\x07", "ab"},
+		{"delete", "a\x7fb", "ab"},
+		{"C1 CSI", "a\u009b2Jb", "a2Jb"},
+		{"C1 OSC and ST", "\u009d0;title\u009cdone", "0;titledone"},
+		{"C1 range ends", "\u0080a\u009f", "a"},
+		{"printable after C1", " é", " é"},
+	}
+	for _, test := range tests {
+		if got := sanitizeText(test.text); got != test.want {
+			t.Errorf("%s: sanitizeText(%q) = %q, want %q", test.name, test.text, got, test.want)
+		}
+	}
+}
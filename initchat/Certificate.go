@@ -0,0 +1,132 @@
+/*
+	Loads the client certificate for mutual TLS from PEM files or a PKCS#12 bundle and
+	reloads it when the files change, so a renewed certificate is used from the next connection on
+ */
+
+package initchat
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"software.sslmate.com/src/go-pkcs12"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Client certificate presented in every TLS handshake, read again once its files are modified
+type CertificateLoader struct {
+	certPath string
+	keyPath string
+	password string
+	mutex sync.Mutex
+	cert *tls.Certificate
+	modTime time.Time
+}
+
+//Whether path names a PKCS#12 bundle rather than a PEM certificate
+func IsPKCS12(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".p12" || ext == ".pfx"
+}
+
+//Loads the certificate right away so a bad path or password is reported before connecting.
+//A PKCS#12 bundle holds the key too, keyPath is then empty and password unlocks the bundle.
+func NewCertificateLoader(certPath string, keyPath string, password string) (*CertificateLoader, error) {
+	loader := &CertificateLoader{certPath: certPath, keyPath: keyPath, password: password}
+	modTime, err := loader.filesModified()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := LoadClientCertificate(certPath, keyPath, password)
+	if err != nil {
+		return nil, err
+	}
+	loader.cert = cert
+	loader.modTime = modTime
+	return loader, nil
+}
+
+//Reads a key pair from PEM files, or from a PKCS#12 bundle when certPath ends in .p12 or .pfx
+func LoadClientCertificate(certPath string, keyPath string, password string) (*tls.Certificate, error) {
+	if !IsPKCS12(certPath) {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}
+	data, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, errors.New("could not decode " + certPath + ": " + err.Error())
+	}
+	cert := &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey: key,
+		Leaf: leaf,
+	}
+	for _, caCert := range chain {
+		cert.Certificate = append(cert.Certificate, caCert.Raw)
+	}
+	return cert, nil
+}
+
+//Latest modification time of the certificate and key files
+func (loader *CertificateLoader) filesModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{loader.certPath, loader.keyPath} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+//Current certificate, reloaded if its files changed. When the new files can't be loaded,
+//for instance while they are half written, the previous certificate stays in use.
+func (loader *CertificateLoader) Certificate() *tls.Certificate {
+	loader.mutex.Lock()
+	defer loader.mutex.Unlock()
+	modTime, err := loader.filesModified()
+	if err != nil || modTime.Equal(loader.modTime) {
+		return loader.cert
+	}
+	cert, err := LoadClientCertificate(loader.certPath, loader.keyPath, loader.password)
+	if err != nil {
+		log.Println("Could not reload client certificate: ", err)
+		return loader.cert
+	}
+	loader.cert = cert
+	loader.modTime = modTime
+	return cert
+}
+
+//Parsed leaf of the current certificate, for showing who it identifies and when it expires
+func (loader *CertificateLoader) Leaf() (*x509.Certificate, error) {
+	cert := loader.Certificate()
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
+
+//For tls.Config.GetClientCertificate, picks up a renewed certificate at every handshake
+func (loader *CertificateLoader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return loader.Certificate(), nil
+}
@@ -0,0 +1,199 @@
+package initchat
+
+import (
+	"../Messages"
+	"./initchattest"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"software.sslmate.com/src/go-pkcs12"
+	"testing"
+	"time"
+)
+
+//Writes a client certificate for commonName issued by the server to PEM files
+func writeClientCert(t *testing.T, server *initchattest.Server, dir string, commonName string) (string, string) {
+	t.Helper()
+	certPEM, keyPEM, err := server.IssueClientCert(commonName, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+//Connects a client that presents loader's certificate, nil to present none
+func connectWithCertificate(t *testing.T, server *initchattest.Server, loader *CertificateLoader) *Client {
+	t.Helper()
+	tlsConfig := server.ClientTLS.Clone()
+	if loader != nil {
+		tlsConfig.GetClientCertificate = loader.GetClientCertificate
+	}
+	dialer := &Dialer{TLSConfig: tlsConfig}
+	client, err := Connect(func() (Transport, error) {
+		return dialer.Dial(server.URL)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func newCertServer(t *testing.T, newServer func() (*initchattest.Server, error)) *initchattest.Server {
+	t.Helper()
+	server, err := newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	server.ServeCertLogin("token1")
+	return server
+}
+
+func TestLoginWithCertificate(t *testing.T) {
+	servers := map[string]func() (*initchattest.Server, error){
+		"tls": initchattest.NewServer,
+		"wss": initchattest.NewWebSocketServer,
+	}
+	for name, newServer := range servers {
+		t.Run(name, func(t *testing.T) {
+			server := newCertServer(t, newServer)
+			certPath, keyPath := writeClientCert(t, server, t.TempDir(), "alice")
+			loader, err := NewCertificateLoader(certPath, keyPath, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := connectWithCertificate(t, server, loader)
+
+			if err := client.LoginWithCertificate(testContext(t), ""); err != nil {
+				t.Fatal(err)
+			}
+			if got := client.SessionToken(); got.Username != "alice" || got.Token != "token1" {
+				t.Errorf("session after certificate login = %+v", got)
+			}
+		})
+	}
+}
+
+func TestLoginWithoutCertificate(t *testing.T) {
+	server := newCertServer(t, initchattest.NewServer)
+	client := connectWithCertificate(t, server, nil)
+
+	if err := client.LoginWithCertificate(testContext(t), "alice"); !errors.Is(err, ErrCertificateRejected) {
+		t.Fatalf("LoginWithCertificate() without a certificate = %v, want ErrCertificateRejected", err)
+	}
+}
+
+func TestRenewSessionWithCertificate(t *testing.T) {
+	server := newCertServer(t, initchattest.NewServer)
+	certPath, keyPath := writeClientCert(t, server, t.TempDir(), "alice")
+	loader, err := NewCertificateLoader(certPath, keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := connectWithCertificate(t, server, loader)
+	if err := client.LoginWithCertificate(testContext(t), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Expect("certLogin").
+		WithBody(&Messages.CertLoginReq{Username: "alice"}).
+		Respond("auth", &Messages.AuthResp{Token: "token2", Username: "alice"})
+	if err := client.RenewSession(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	verifyServer(t, server)
+	if got := client.SessionToken(); got.Token != "token2" {
+		t.Errorf("token after renewing = %q, want token2", got.Token)
+	}
+}
+
+func TestCertificateReloadedOnReconnect(t *testing.T) {
+	server := newCertServer(t, initchattest.NewServer)
+	dir := t.TempDir()
+	certPath, keyPath := writeClientCert(t, server, dir, "alice")
+	loader, err := NewCertificateLoader(certPath, keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := connectWithCertificate(t, server, loader)
+	if err := client.LoginWithCertificate(testContext(t), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	certPath, keyPath = writeClientCert(t, server, dir, "bob")
+	//File systems with coarse timestamps could otherwise hide the rewrite
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certPath, later, later)
+	os.Chtimes(keyPath, later, later)
+	server.Expect("resume").RespondError("authErr", CodeInvalidCredentials, "token expired")
+	server.DropConnections()
+
+	waitForState(t, client, Connected)
+	verifyServer(t, server)
+	if got := client.SessionToken(); got.Username != "bob" {
+		t.Errorf("user after reconnecting with the renewed certificate = %q, want bob", got.Username)
+	}
+}
+
+func TestCertificateReloadKeepsWorkingCertificate(t *testing.T) {
+	server := newCertServer(t, initchattest.NewServer)
+	certPath, keyPath := writeClientCert(t, server, t.TempDir(), "alice")
+	loader, err := NewCertificateLoader(certPath, keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := ioutil.WriteFile(certPath, []byte("half written"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(certPath, later, later)
+
+	if leaf, err := loader.Leaf(); err != nil || leaf.Subject.CommonName != "alice" {
+		t.Fatalf("Leaf() after a broken rewrite = %v, %v, want the previous certificate", leaf, err)
+	}
+}
+
+func TestPKCS12Certificate(t *testing.T) {
+	server := newCertServer(t, initchattest.NewServer)
+	certPEM, keyPEM, err := server.IssueClientCert("carol", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := pkcs12.Modern.Encode(pair.PrivateKey, pair.Leaf, nil, "bundle password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "carol.p12")
+	if err := ioutil.WriteFile(path, bundle, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewCertificateLoader(path, "", "wrong"); err == nil {
+		t.Fatal("NewCertificateLoader() accepted the wrong bundle password")
+	}
+	loader, err := NewCertificateLoader(path, "", "bundle password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := connectWithCertificate(t, server, loader)
+	if err := client.LoginWithCertificate(testContext(t), ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.SessionToken().Username; got != "carol" {
+		t.Errorf("user of the PKCS#12 certificate = %q, want carol", got)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+	Package initchat implements the InitChat client protocol so that frontends and bots
+	can talk to the server without any terminal code
+ */
+
+package initchat
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var PreHeaderLength = 2
+
+var ErrDisconnected = errors.New("Not connected to server")
+
+//How long Close waits for frames already sent to be written
+var FlushTimeout = 5 * time.Second
+
+//Returned when the server doesn't answer a request before its context deadline
+type TimeoutError struct {
+	Request string
+}
+
+func (err *TimeoutError) Error() string {
+	return "Timed out waiting for the server to answer " + err.Request
+}
+
+type Message struct {
+	typeID string
+	requestID uint32
+	body []byte
+	client *Client
+	//Closed by runSend instead of writing a frame, once every frame queued before it is written
+	flushed chan struct{}
+}
+
+func (msg *Message) TypeID() string {
+	return msg.typeID
+}
+
+//Serialized protobuf body of the frame
+func (msg *Message) Body() []byte {
+	return msg.body
+}
+
+type Client struct {
+	connection Transport
+	connMutex sync.Mutex
+	connDone chan struct{}
+	closed bool
+	dial DialFunc
+	sendChannel chan *Message
+	recvChannel chan *Message
+	disconnectChannel chan *Client
+	stateChannel chan ConnState
+	dispatcher *Dispatcher
+	session Session
+	pendingMutex sync.Mutex
+	pending map[uint32]chan *Message
+	nextRequestID uint32
+	uploadKeysMutex sync.Mutex
+	//Keys of encrypted copies an interrupted UploadEncrypted left, by transfer ID
+	uploadKeys map[string][]byte
+}
+
+//Opens the first connection with dial and keeps redialing with it whenever the connection drops
+func Connect(dial DialFunc) (*Client, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		dial: dial,
+		sendChannel: make(chan *Message),
+		recvChannel: make(chan *Message),
+		disconnectChannel: make(chan *Client),
+		stateChannel: make(chan ConnState, 8),
+		dispatcher: NewDispatcher(DispatchBufferSize, logUnhandled),
+		uploadKeys: make(map[string][]byte),
+	}
+	client.setConnection(conn)
+	go client.runSend()
+	go client.runRead(conn)
+	go client.runNetEvents()
+	return client, nil
+}
+
+//Disconnects for good once the frames already sent are written, stopping any reconnect attempts
+func (client *Client) Close() {
+	client.flush()
+	client.connMutex.Lock()
+	client.closed = true
+	conn := client.connection
+	client.connMutex.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+//Waits until runSend has written every frame handed to it before the call, or FlushTimeout passes
+func (client *Client) flush() {
+	flushed := make(chan struct{})
+	timeout := time.NewTimer(FlushTimeout)
+	defer timeout.Stop()
+	select {
+	case client.sendChannel <- &Message{flushed: flushed}:
+	case <-timeout.C:
+		return
+	}
+	select {
+	case <-flushed:
+	case <-timeout.C:
+	}
+}
+
+func (client *Client) isClosed() bool {
+	client.connMutex.Lock()
+	defer client.connMutex.Unlock()
+	return client.closed
+}
+
+//Connection state changes, dropped if nobody is reading
+func (client *Client) States() <-chan ConnState {
+	return client.stateChannel
+}
+
+//Subscribes to raw frames of the given types, for server pushes the SDK has no method for
+func (client *Client) Subscribe(typeIDs ...string) *Subscription {
+	return client.dispatcher.Register(typeIDs...)
+}
+
+func (client *Client) conn() Transport {
+	client.connMutex.Lock()
+	defer client.connMutex.Unlock()
+	return client.connection
+}
+
+//Closes the done channel of the previous connection so waiting requests fail fast
+func (client *Client) setConnection(conn Transport) {
+	client.connMutex.Lock()
+	defer client.connMutex.Unlock()
+	if client.connDone != nil {
+		close(client.connDone)
+		client.connDone = nil
+	}
+	client.connection = conn
+	if conn != nil {
+		client.connDone = make(chan struct{})
+	}
+}
+
+//Channel closed when the current connection is lost, nil while disconnected
+func (client *Client) done() <-chan struct{} {
+	client.connMutex.Lock()
+	defer client.connMutex.Unlock()
+	return client.connDone
+}
+
+func (client *Client) send(ctx context.Context, typeID string, body []byte) error {
+	msg := Message {
+		typeID: typeID,
+		body: body,
+	}
+	return client.enqueue(ctx, &msg)
+}
+
+func (client *Client) enqueue(ctx context.Context, msg *Message) error {
+	//Caught here so the caller gets the error instead of runSend dropping the frame
+	if len(msg.body) > MaxBodySize {
+		return &FrameError{
+			Part: "body",
+			Reason: msg.typeID + " is " + strconv.Itoa(len(msg.body)) + " bytes, over the limit of " + strconv.Itoa(MaxBodySize),
+			Err: ErrFrameTooLarge,
+		}
+	}
+	done := client.done()
+	if done == nil {
+		return ErrDisconnected
+	}
+	select {
+	case client.sendChannel <- msg:
+		return nil
+	case <-done:
+		return ErrDisconnected
+	case <-ctx.Done():
+		return contextErr(ctx, msg.typeID)
+	}
+}
+
+//Converts a finished context into the error reported to the caller of a request
+func contextErr(ctx context.Context, typeID string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Request: typeID}
+	}
+	return ctx.Err()
+}
+
+//Reserves a fresh request ID and returns the channel its response is routed to
+func (client *Client) addPending() (uint32, chan *Message) {
+	respChannel := make(chan *Message, 1)
+	client.pendingMutex.Lock()
+	if client.pending == nil {
+		client.pending = map[uint32]chan *Message{}
+	}
+	client.nextRequestID++
+	if client.nextRequestID == 0 {
+		client.nextRequestID++
+	}
+	requestID := client.nextRequestID
+	client.pending[requestID] = respChannel
+	client.pendingMutex.Unlock()
+	return requestID, respChannel
+}
+
+func (client *Client) finishRequest(requestID uint32) {
+	client.pendingMutex.Lock()
+	defer client.pendingMutex.Unlock()
+	delete(client.pending, requestID)
+}
+
+//Delivers a response to the caller waiting on its request ID, false if nobody is waiting
+func (client *Client) routeResponse(msg *Message) bool {
+	client.pendingMutex.Lock()
+	respChannel, isPending := client.pending[msg.requestID]
+	delete(client.pending, msg.requestID)
+	client.pendingMutex.Unlock()
+	if !isPending {
+		return false
+	}
+	respChannel <- msg
+	return true
+}
+
+func (client *Client) runSend() {
+	for {
+		msg := <-client.sendChannel
+		if msg.flushed != nil {
+			close(msg.flushed)
+			continue
+		}
+		conn := client.conn()
+		if conn == nil {
+			log.Println("Not connected, dropped message: ", msg.typeID)
+			continue
+		}
+		data, err := EncodeFrame(msg.typeID, msg.requestID, msg.body)
+		if err != nil {
+			log.Println("Dropped message: ", err)
+			continue
+		}
+		_, writeErr := conn.Write(data)
+		//nBytes, writeErr := writer.Write(data)
+		if writeErr != nil {
+			log.Println("WriteErr: ", writeErr)
+			//Closing makes runRead fail so the disconnect is handled in one place
+			conn.Close()
+		}
+	}
+}
+
+func (client *Client) onDisconnect() {
+	client.disconnectChannel <- client
+}
+
+func (client *Client) runRead(conn Transport) {
+	reader := bufio.NewReader(conn)
+	defer client.onDisconnect()
+	for {
+		message, err := ReadFrame(reader)
+		if err != nil {
+			//After a bad frame the stream can't be resynchronized, so reconnect instead
+			log.Println("Client Disconnected: ", err)
+			return
+		}
+		message.client = client
+		client.recvChannel <- message
+	}
+}
\ No newline at end of file
@@ -0,0 +1,555 @@
+package initchat
+
+import (
+	"../Messages"
+	"./initchattest"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	//Set once up front, reconnect goroutines of finished tests may still be reading it
+	ReconnectBaseDelay = 10 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+//Connects a client to a fresh mock server, both are closed when the test ends
+func newTestClient(t *testing.T) (*initchattest.Server, *Client) {
+	t.Helper()
+	return connectTestServer(t, initchattest.NewServer)
+}
+
+//Connects a client to a server started by newServer, over the transport its URL names
+func connectTestServer(t *testing.T, newServer func() (*initchattest.Server, error)) (*initchattest.Server, *Client) {
+	t.Helper()
+	server, err := newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Connect(func() (Transport, error) {
+		return Dial(server.URL, server.ClientTLS)
+	})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func verifyServer(t *testing.T, server *initchattest.Server) {
+	t.Helper()
+	if err := server.Wait(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignUp(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("signUp").
+		WithBody(&Messages.SignUpReq{Username: "alice", Password: "secret"}).
+		Respond("auth", &Messages.AuthResp{Token: "token1", ExpireTime: 4000000000})
+
+	if err := client.SignUp(testContext(t), "alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	want := SessionToken{Username: "alice", Token: "token1", ExpireTime: 4000000000}
+	if got := client.SessionToken(); got != want {
+		t.Errorf("SessionToken() = %+v, want %+v", got, want)
+	}
+	verifyServer(t, server)
+}
+
+func TestSignUpTaken(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("signUp").RespondError("authErr", CodeUsernameTaken, "")
+
+	err := client.SignUp(testContext(t), "alice", "secret")
+	if !errors.Is(err, ErrUsernameTaken) {
+		t.Fatalf("SignUp() error = %v, want ErrUsernameTaken", err)
+	}
+	if got := client.SessionToken(); got.Username != "" {
+		t.Errorf("session was set after a failed sign up: %+v", got)
+	}
+	verifyServer(t, server)
+}
+
+func TestLogin(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("login").
+		WithBody(&Messages.LoginReq{Username: "bob", Password: "hunter2"}).
+		Respond("auth", &Messages.AuthResp{Token: "token2"})
+
+	if err := client.Login(testContext(t), "bob", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.SessionToken(); got.Username != "bob" || got.Token != "token2" {
+		t.Errorf("SessionToken() = %+v", got)
+	}
+	verifyServer(t, server)
+}
+
+func TestLoginInvalidCredentials(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("login").RespondError("authErr", CodeInvalidCredentials, "wrong password")
+
+	err := client.Login(testContext(t), "bob", "nope")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Login() error = %v, want ErrInvalidCredentials", err)
+	}
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Request != "login" || err.Error() != "wrong password" {
+		t.Errorf("Login() error = %#v", err)
+	}
+	verifyServer(t, server)
+}
+
+func TestResumeKeepsToken(t *testing.T) {
+	server, client := newTestClient(t)
+	//Servers may confirm a resume without issuing a new token
+	server.Expect("resume").
+		WithBody(&Messages.ResumeReq{Token: "saved"}).
+		Respond("auth", &Messages.AuthResp{ExpireTime: 4000000000})
+
+	if err := client.Resume(testContext(t), "carol", "saved"); err != nil {
+		t.Fatal(err)
+	}
+	want := SessionToken{Username: "carol", Token: "saved", ExpireTime: 4000000000}
+	if got := client.SessionToken(); got != want {
+		t.Errorf("SessionToken() = %+v, want %+v", got, want)
+	}
+	if err := client.RenewSession(testContext(t)); err != errNoPassword {
+		t.Errorf("RenewSession() after resume = %v, want errNoPassword", err)
+	}
+	verifyServer(t, server)
+}
+
+func TestRenewSession(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("login").Respond("auth", &Messages.AuthResp{Token: "old", ExpireTime: 100})
+	server.Expect("login").
+		WithBody(&Messages.LoginReq{Username: "dave", Password: "pw"}).
+		Respond("auth", &Messages.AuthResp{Token: "new", ExpireTime: 200})
+
+	if err := client.Login(testContext(t), "dave", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RenewSession(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.SessionToken(); got.Token != "new" || got.ExpireTime != 200 {
+		t.Errorf("SessionToken() = %+v", got)
+	}
+	verifyServer(t, server)
+}
+
+func TestCreateGroup(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("createGroup").
+		WithBody(&Messages.CreateGroupReq{GroupName: "team"}).
+		Respond("group", &Messages.GroupResp{})
+	server.Expect("createGroup").RespondError("createGroupErr", CodeGroupExists, "")
+
+	if _, err := client.CreateGroup(testContext(t), "team"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CreateGroup(testContext(t), "team"); !errors.Is(err, ErrGroupExists) {
+		t.Errorf("CreateGroup() of an existing group = %v, want ErrGroupExists", err)
+	}
+	verifyServer(t, server)
+}
+
+func TestJoinAndRefreshGroup(t *testing.T) {
+	server, client := newTestClient(t)
+	history := []*Messages.TextMessage{
+		{Username: "alice", Message: "hello", Time: 1},
+		{Username: "bob", Message: "hi", Time: 2},
+	}
+	server.Expect("joinGroup").
+		WithBody(&Messages.JoinGroupReq{GroupName: "team"}).
+		Respond("group", &Messages.GroupResp{Messages: history})
+	server.Expect("refreshGroup").
+		Respond("group", &Messages.GroupResp{Messages: history[:1]})
+
+	group, err := client.JoinGroup(testContext(t), "team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Messages) != 2 || group.Messages[1].Message != "hi" {
+		t.Errorf("JoinGroup() messages = %v", group.Messages)
+	}
+	refreshed, err := client.RefreshGroup(testContext(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refreshed.Messages) != 1 {
+		t.Errorf("RefreshGroup() messages = %v", refreshed.Messages)
+	}
+	verifyServer(t, server)
+}
+
+func TestJoinGroupNotMember(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("joinGroup").RespondError("joinGroupErr", CodeNotMember, "")
+
+	if _, err := client.JoinGroup(testContext(t), "secret"); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("JoinGroup() error = %v, want ErrNotMember", err)
+	}
+	verifyServer(t, server)
+}
+
+func TestSendTextAndStream(t *testing.T) {
+	server, client := newTestClient(t)
+	stream := client.TextMessages()
+	defer stream.Close()
+	echo := &Messages.TextMessage{Username: "alice", Message: "ping", Time: 42}
+	server.Expect("textMsg").
+		WithBody(&Messages.TextMessageReq{Message: "ping"}).
+		WithoutRequestID().
+		Push("message", echo)
+
+	if err := client.SendText(testContext(t), "ping"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case textMsg := <-stream.Messages():
+		if textMsg.Username != "alice" || textMsg.Message != "ping" || textMsg.Time != 42 {
+			t.Errorf("streamed message = %v", textMsg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pushed message never reached the stream")
+	}
+	verifyServer(t, server)
+}
+
+func TestTextStreamClose(t *testing.T) {
+	_, client := newTestClient(t)
+	stream := client.TextMessages()
+	stream.Close()
+	select {
+	case _, open := <-stream.Messages():
+		if open {
+			t.Fatal("stream delivered a message after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream channel was not closed")
+	}
+}
+
+func TestMalformedResponse(t *testing.T) {
+	//Field 1 claims five bytes but the frame ends after one
+	truncated := []byte{0x0a, 0x05, 'a'}
+	tests := []struct {
+		name string
+		request string
+		response string
+		call func(ctx context.Context, client *Client) error
+	}{
+		{"Groups", "getGroups", "getGroups", func(ctx context.Context, client *Client) error {
+			_, err := client.Groups(ctx)
+			return err
+		}},
+		{"Invites", "getInvites", "getInvites", func(ctx context.Context, client *Client) error {
+			_, err := client.Invites(ctx)
+			return err
+		}},
+		{"AcceptInvite", "acceptInvite", "getInvites", func(ctx context.Context, client *Client) error {
+			_, err := client.AcceptInvite(ctx, "invite1")
+			return err
+		}},
+		{"DeclineInvite", "deleteInvite", "getInvites", func(ctx context.Context, client *Client) error {
+			_, err := client.DeclineInvite(ctx, "invite1")
+			return err
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, client := newTestClient(t)
+			server.Expect(test.request).RespondRaw(test.response, truncated)
+
+			if err := test.call(testContext(t), client); err == nil {
+				t.Fatalf("%s() accepted a malformed response", test.name)
+			}
+			verifyServer(t, server)
+		})
+	}
+}
+
+func TestTextStreamCloseWithoutReading(t *testing.T) {
+	server, client := newTestClient(t)
+	stream := client.TextMessages()
+	//Fills the stream and the subscription behind it, so forwarding blocks
+	deadline := time.Now().Add(5 * time.Second)
+	for len(stream.Messages()) < DispatchBufferSize || len(stream.sub.Messages()) < DispatchBufferSize {
+		if time.Now().After(deadline) {
+			t.Fatal("pushed messages never filled the stream")
+		}
+		if err := server.Push("message", &Messages.TextMessage{Message: "flood"}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stream.Close()
+
+	received := 0
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-stream.Messages():
+			if open {
+				received++
+			}
+		case <-timeout:
+			t.Fatal("stream channel was not closed")
+		}
+	}
+	if received > DispatchBufferSize + 1 {
+		t.Errorf("stream forwarded %d messages after Close, want at most the %d buffered and one in flight", received, DispatchBufferSize)
+	}
+}
+
+func TestLeaveGroup(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("joinGroup").Respond("group", &Messages.GroupResp{})
+	server.Expect("leaveGroup").WithoutRequestID()
+
+	if _, err := client.JoinGroup(testContext(t), "team"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.LeaveGroup(testContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, groupName := client.session.snapshot(); groupName != "" {
+		t.Errorf("group %q is still restored after leaving", groupName)
+	}
+	verifyServer(t, server)
+}
+
+func TestSearchUsers(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("searchUsers").
+		WithBody(&Messages.UserSearchReq{UsernamePrefix: "al"}).
+		Respond("userSearchResp", &Messages.UserSearchResp{Usernames: []string{"alice", "alan"}})
+
+	usernames, err := client.SearchUsers(testContext(t), "al")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(usernames, []string{"alice", "alan"}) {
+		t.Errorf("SearchUsers() = %v", usernames)
+	}
+	verifyServer(t, server)
+}
+
+func TestInvite(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("invite").
+		WithBody(&Messages.InviteReq{Username: "erin"}).
+		WithoutRequestID()
+
+	if err := client.Invite(testContext(t), "erin"); err != nil {
+		t.Fatal(err)
+	}
+	verifyServer(t, server)
+}
+
+func TestGroups(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("getGroups").Respond("getGroups", &Messages.GroupsResp{GroupNames: []string{"team", "family"}})
+	server.Expect("getGroups").RespondError("getGroupsErr", CodeRateLimited, "")
+
+	groupNames, err := client.Groups(testContext(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(groupNames, []string{"team", "family"}) {
+		t.Errorf("Groups() = %v", groupNames)
+	}
+	if _, err := client.Groups(testContext(t)); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Groups() error = %v, want ErrRateLimited", err)
+	}
+	verifyServer(t, server)
+}
+
+func TestInvites(t *testing.T) {
+	server, client := newTestClient(t)
+	first := &Messages.InvitesResp_Invite{InviteID: "i1", FromUsername: "alice", GroupName: "team"}
+	second := &Messages.InvitesResp_Invite{InviteID: "i2", FromUsername: "bob", GroupName: "band"}
+	server.Expect("getInvites").Respond("getInvites", &Messages.InvitesResp{Invites: []*Messages.InvitesResp_Invite{first, second}})
+	server.Expect("acceptInvite").
+		WithBody(&Messages.AcceptInviteReq{InviteID: "i1"}).
+		Respond("getInvites", &Messages.InvitesResp{Invites: []*Messages.InvitesResp_Invite{second}})
+	server.Expect("deleteInvite").
+		WithBody(&Messages.DeleteInviteReq{InviteID: "i2"}).
+		Respond("getInvites", &Messages.InvitesResp{})
+	server.Expect("acceptInvite").RespondError("acceptInviteErr", CodeNotMember, "invite expired")
+
+	invites, err := client.Invites(testContext(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invites) != 2 || invites[1].GroupName != "band" {
+		t.Errorf("Invites() = %v", invites)
+	}
+	remaining, err := client.AcceptInvite(testContext(t), "i1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].InviteID != "i2" {
+		t.Errorf("AcceptInvite() = %v", remaining)
+	}
+	remaining, err = client.DeclineInvite(testContext(t), "i2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("DeclineInvite() = %v", remaining)
+	}
+	if _, err := client.AcceptInvite(testContext(t), "i1"); err == nil || err.Error() != "invite expired" {
+		t.Errorf("AcceptInvite() of an expired invite = %v", err)
+	}
+	verifyServer(t, server)
+}
+
+//Servers that predate request IDs are matched by the response type instead
+func TestResponsesWithoutRequestIDs(t *testing.T) {
+	server, client := newTestClient(t)
+	server.OmitRequestIDs()
+	server.Expect("getGroups").Respond("getGroups", &Messages.GroupsResp{GroupNames: []string{"team"}})
+	server.Expect("login").RespondError("authErr", CodeInvalidCredentials, "")
+
+	groupNames, err := client.Groups(testContext(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groupNames) != 1 {
+		t.Errorf("Groups() = %v", groupNames)
+	}
+	if err := client.Login(testContext(t), "bob", "nope"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login() error = %v, want ErrInvalidCredentials", err)
+	}
+	verifyServer(t, server)
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	server, client := newTestClient(t)
+	//Answers arrive in the opposite order of the requests, request IDs still route them
+	held := make(chan func(), 1)
+	server.Expect("getGroups").Do(func(conn *initchattest.Conn, frame initchattest.Frame) {
+		held <- func() {
+			conn.Send("getGroups", frame.RequestID, &Messages.GroupsResp{GroupNames: []string{"team"}})
+		}
+	})
+	server.Expect("searchUsers").Do(func(conn *initchattest.Conn, frame initchattest.Frame) {
+		conn.Send("userSearchResp", frame.RequestID, &Messages.UserSearchResp{Usernames: []string{"alice"}})
+		(<-held)()
+	})
+
+	groupsDone := make(chan []string)
+	go func() {
+		groupNames, _ := client.Groups(testContext(t))
+		groupsDone <- groupNames
+	}()
+	for len(server.Received()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	usernames, err := client.SearchUsers(testContext(t), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usernames) != 1 || usernames[0] != "alice" {
+		t.Errorf("SearchUsers() = %v", usernames)
+	}
+	if groupNames := <-groupsDone; len(groupNames) != 1 || groupNames[0] != "team" {
+		t.Errorf("Groups() = %v", groupNames)
+	}
+	verifyServer(t, server)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("getGroups")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	_, err := client.Groups(ctx)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Request != "getGroups" {
+		t.Fatalf("Groups() error = %v, want a TimeoutError", err)
+	}
+	verifyServer(t, server)
+}
+
+//The answer to a request that timed out arrives while another request waits on the same response type
+func TestLateResponseDropped(t *testing.T) {
+	server, client := newTestClient(t)
+	staleID := make(chan uint32, 1)
+	server.Expect("joinGroup").Do(func(conn *initchattest.Conn, frame initchattest.Frame) {
+		staleID <- frame.RequestID
+	})
+	server.Expect("joinGroup").Do(func(conn *initchattest.Conn, frame initchattest.Frame) {
+		conn.Send("group", <-staleID, &Messages.GroupResp{Messages: []*Messages.TextMessage{{Message: "stale"}}})
+		time.Sleep(50 * time.Millisecond)
+		conn.Send("group", frame.RequestID, &Messages.GroupResp{Messages: []*Messages.TextMessage{{Message: "fresh"}}})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	if _, err := client.JoinGroup(ctx, "old"); err == nil {
+		t.Fatal("JoinGroup() without an answer succeeded")
+	}
+	group, err := client.JoinGroup(testContext(t), "team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Messages) != 1 || group.Messages[0].Message != "fresh" {
+		t.Errorf("JoinGroup() = %v, want the answer to its own request", group.Messages)
+	}
+	verifyServer(t, server)
+}
+
+func TestDisconnectFailsPendingRequest(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("getInvites").Disconnect()
+
+	if _, err := client.Invites(testContext(t)); err != ErrDisconnected {
+		t.Fatalf("Invites() error = %v, want ErrDisconnected", err)
+	}
+	verifyServer(t, server)
+}
+
+func TestSubscribeToPushes(t *testing.T) {
+	server, client := newTestClient(t)
+	sub := client.Subscribe("announcement")
+	defer sub.Unregister()
+	for server.Connections() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := server.Push("announcement", &Messages.Error{Message: "maintenance at noon"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-sub.Messages():
+		if msg.TypeID() != "announcement" {
+			t.Errorf("TypeID() = %q", msg.TypeID())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("push never reached the subscription")
+	}
+}
@@ -0,0 +1,107 @@
+/*
+	Routes received messages to every subscriber of their type ID
+ */
+
+package initchat
+
+import (
+	"log"
+	"sync"
+)
+
+var DispatchBufferSize = 32
+
+type Subscription struct {
+	id uint64
+	typeIDs []string
+	messages chan *Message
+	dispatcher *Dispatcher
+}
+
+//Channel the subscribed messages arrive on, closed once unregistered
+func (sub *Subscription) Messages() <-chan *Message {
+	return sub.messages
+}
+
+func (sub *Subscription) Unregister() {
+	sub.dispatcher.Unregister(sub)
+}
+
+type Dispatcher struct {
+	mutex sync.RWMutex
+	subscribers map[string]map[uint64]*Subscription
+	nextID uint64
+	bufferSize int
+	defaultHandler func(*Message)
+}
+
+func NewDispatcher(bufferSize int, defaultHandler func(*Message)) *Dispatcher {
+	return &Dispatcher{
+		subscribers: map[string]map[uint64]*Subscription{},
+		bufferSize: bufferSize,
+		defaultHandler: defaultHandler,
+	}
+}
+
+//Subscribes one buffered channel to all of the given type IDs
+func (dispatcher *Dispatcher) Register(typeIDs ...string) *Subscription {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	dispatcher.nextID++
+	sub := &Subscription{
+		id: dispatcher.nextID,
+		typeIDs: typeIDs,
+		messages: make(chan *Message, dispatcher.bufferSize),
+		dispatcher: dispatcher,
+	}
+	for _, typeID := range typeIDs {
+		subs, hasSubs := dispatcher.subscribers[typeID]
+		if !hasSubs {
+			subs = map[uint64]*Subscription{}
+			dispatcher.subscribers[typeID] = subs
+		}
+		subs[sub.id] = sub
+	}
+	return sub
+}
+
+//Removes the subscription and closes its channel, safe to call more than once
+func (dispatcher *Dispatcher) Unregister(sub *Subscription) {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	registered := false
+	for _, typeID := range sub.typeIDs {
+		if subs, hasSubs := dispatcher.subscribers[typeID]; hasSubs {
+			if _, isSub := subs[sub.id]; isSub {
+				registered = true
+				delete(subs, sub.id)
+			}
+			if len(subs) == 0 {
+				delete(dispatcher.subscribers, typeID)
+			}
+		}
+	}
+	if registered {
+		close(sub.messages)
+	}
+}
+
+//Delivers without blocking; a subscriber whose buffer is full misses the message
+func (dispatcher *Dispatcher) Dispatch(message *Message) {
+	dispatcher.mutex.RLock()
+	defer dispatcher.mutex.RUnlock()
+	subs := dispatcher.subscribers[message.typeID]
+	if len(subs) == 0 {
+		if dispatcher.defaultHandler != nil {
+			dispatcher.defaultHandler(message)
+		}
+		return
+	}
+	for _, sub := range subs {
+		select {
+		case sub.messages <- message:
+		default:
+			log.Println("Subscriber buffer full, dropped message: ", message.typeID)
+		}
+	}
+}
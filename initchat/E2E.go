@@ -0,0 +1,215 @@
+/*
+	End-to-end encryption of group text, carried inside ordinary text messages so the server only relays it
+ */
+
+package initchat
+
+import (
+	"../Messages"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"strings"
+)
+
+//Prefixes marking text that carries a public key or a sealed message instead of plain text
+const (
+	KeyAnnouncementPrefix = "~e2ekey1:"
+	SealedPrefix = "~e2e1:"
+)
+
+var ErrNotRecipient = errors.New("message was not encrypted for this key")
+var ErrMalformedSealed = errors.New("encrypted message is malformed")
+
+//Key pair a user's messages are sealed and opened with
+type Identity struct {
+	private *ecdh.PrivateKey
+}
+
+func GenerateIdentity() (*Identity, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{private: private}, nil
+}
+
+//Restores an identity from the bytes Identity.Bytes returned
+func ParseIdentity(privateKey []byte) (*Identity, error) {
+	private, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{private: private}, nil
+}
+
+//Private key, keep it secret
+func (id *Identity) Bytes() []byte {
+	return id.private.Bytes()
+}
+
+func (id *Identity) PublicKey() []byte {
+	return id.private.PublicKey().Bytes()
+}
+
+//SHA-256 of a public key in groups of four hex digits, for comparing keys out of band
+func Fingerprint(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	digits := hex.EncodeToString(sum[:16])
+	var groups []string
+	for i := 0; i < len(digits); i += 4 {
+		groups = append(groups, digits[i:i + 4])
+	}
+	return strings.Join(groups, " ")
+}
+
+//Text that publishes the public key to the group
+func KeyAnnouncement(publicKey []byte) string {
+	return KeyAnnouncementPrefix + base64.StdEncoding.EncodeToString(publicKey)
+}
+
+//Returns the public key if text is a key announcement
+func ParseKeyAnnouncement(text string) ([]byte, bool) {
+	if !strings.HasPrefix(text, KeyAnnouncementPrefix) {
+		return nil, false
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(text[len(KeyAnnouncementPrefix):])
+	if err != nil || len(publicKey) != 32 {
+		return nil, false
+	}
+	return publicKey, true
+}
+
+func IsSealed(text string) bool {
+	return strings.HasPrefix(text, SealedPrefix)
+}
+
+//Key that wraps a message key between one sender and one recipient
+func (id *Identity) wrapKey(peerKey []byte, senderKey []byte, recipientKey []byte) (cipher.AEAD, error) {
+	peer, err := ecdh.X25519().NewPublicKey(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := id.private.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	info := append(append([]byte("initchat e2e wrap"), senderKey...), recipientKey...)
+	kek := make([]byte, 32)
+	if _, readErr := io.ReadFull(hkdf.New(sha256.New, shared, nil, info), kek); readErr != nil {
+		return nil, readErr
+	}
+	return newGCM(kek)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//Encrypts text under a fresh key and wraps that key for each recipient's public key
+func (id *Identity) Seal(text string, recipients [][]byte) (string, error) {
+	if len(recipients) == 0 {
+		return "", errors.New("no recipients to encrypt for")
+	}
+	senderKey := id.PublicKey()
+	messageKey, err := randomBytes(32)
+	if err != nil {
+		return "", err
+	}
+	messageAEAD, err := newGCM(messageKey)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomBytes(messageAEAD.NonceSize())
+	if err != nil {
+		return "", err
+	}
+	envelope := Messages.E2EEnvelope{
+		SenderKey: senderKey,
+		Nonce: nonce,
+		Ciphertext: messageAEAD.Seal(nil, nonce, []byte(text), senderKey),
+	}
+	for _, recipientKey := range recipients {
+		wrapAEAD, wrapErr := id.wrapKey(recipientKey, senderKey, recipientKey)
+		if wrapErr != nil {
+			return "", wrapErr
+		}
+		wrapNonce, randErr := randomBytes(wrapAEAD.NonceSize())
+		if randErr != nil {
+			return "", randErr
+		}
+		envelope.Keys = append(envelope.Keys, &Messages.E2EEnvelope_WrappedKey{
+			RecipientKey: recipientKey,
+			Nonce: wrapNonce,
+			Wrapped: wrapAEAD.Seal(nil, wrapNonce, messageKey, senderKey),
+		})
+	}
+	data, err := proto.Marshal(&envelope)
+	if err != nil {
+		return "", err
+	}
+	return SealedPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+//Decrypts sealed text, returning the plain text and the public key of whoever sealed it
+func (id *Identity) Open(sealed string) (string, []byte, error) {
+	if !IsSealed(sealed) {
+		return "", nil, ErrMalformedSealed
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed[len(SealedPrefix):])
+	if err != nil {
+		return "", nil, ErrMalformedSealed
+	}
+	envelope := Messages.E2EEnvelope{}
+	if parseErr := proto.Unmarshal(data, &envelope); parseErr != nil {
+		return "", nil, ErrMalformedSealed
+	}
+	ownKey := id.PublicKey()
+	for _, wrapped := range envelope.Keys {
+		if !bytes.Equal(wrapped.RecipientKey, ownKey) {
+			continue
+		}
+		wrapAEAD, wrapErr := id.wrapKey(envelope.SenderKey, envelope.SenderKey, ownKey)
+		if wrapErr != nil {
+			return "", nil, ErrMalformedSealed
+		}
+		if len(wrapped.Nonce) != wrapAEAD.NonceSize() {
+			return "", nil, ErrMalformedSealed
+		}
+		messageKey, openErr := wrapAEAD.Open(nil, wrapped.Nonce, wrapped.Wrapped, envelope.SenderKey)
+		if openErr != nil {
+			return "", nil, errors.New("message key could not be decrypted: " + openErr.Error())
+		}
+		messageAEAD, gcmErr := newGCM(messageKey)
+		if gcmErr != nil || len(envelope.Nonce) != messageAEAD.NonceSize() {
+			return "", nil, ErrMalformedSealed
+		}
+		plain, openErr := messageAEAD.Open(nil, envelope.Nonce, envelope.Ciphertext, envelope.SenderKey)
+		if openErr != nil {
+			return "", nil, errors.New("message could not be decrypted: " + openErr.Error())
+		}
+		return string(plain), envelope.SenderKey, nil
+	}
+	return "", nil, ErrNotRecipient
+}
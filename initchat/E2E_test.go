@@ -0,0 +1,191 @@
+package initchat
+
+import (
+	"../Messages"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"testing"
+)
+
+func mustIdentity(t *testing.T) *Identity {
+	t.Helper()
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+func mustSeal(t *testing.T, sender *Identity, text string, recipients ...*Identity) string {
+	t.Helper()
+	var keys [][]byte
+	for _, recipient := range recipients {
+		keys = append(keys, recipient.PublicKey())
+	}
+	sealed, err := sender.Seal(text, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+//Decodes a sealed message so a test can change it and seal it back up with resealEnvelope
+func decodeEnvelope(t *testing.T, sealed string) *Messages.E2EEnvelope {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(sealed[len(SealedPrefix):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := &Messages.E2EEnvelope{}
+	if parseErr := proto.Unmarshal(data, envelope); parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	return envelope
+}
+
+func resealEnvelope(t *testing.T, envelope *Messages.E2EEnvelope) string {
+	t.Helper()
+	data, err := proto.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SealedPrefix + base64.StdEncoding.EncodeToString(data)
+}
+
+func TestSealOpen(t *testing.T) {
+	alice := mustIdentity(t)
+	bob := mustIdentity(t)
+	carol := mustIdentity(t)
+	sealed := mustSeal(t, alice, "meet at noon", alice, bob, carol)
+	if !IsSealed(sealed) {
+		t.Fatalf("sealed text %q lacks the sealed prefix", sealed)
+	}
+	for name, recipient := range map[string]*Identity{"alice": alice, "bob": bob, "carol": carol} {
+		text, senderKey, err := recipient.Open(sealed)
+		if err != nil {
+			t.Errorf("%s could not open: %v", name, err)
+			continue
+		}
+		if text != "meet at noon" {
+			t.Errorf("%s opened %q", name, text)
+		}
+		if !bytes.Equal(senderKey, alice.PublicKey()) {
+			t.Errorf("%s got sender key %x, want alice's", name, senderKey)
+		}
+	}
+}
+
+func TestSealEmptyText(t *testing.T) {
+	alice := mustIdentity(t)
+	text, _, err := alice.Open(mustSeal(t, alice, "", alice))
+	if err != nil || text != "" {
+		t.Fatalf("Open = %q, %v", text, err)
+	}
+}
+
+func TestSealNoRecipients(t *testing.T) {
+	if _, err := mustIdentity(t).Seal("hello", nil); err == nil {
+		t.Fatal("Seal without recipients succeeded")
+	}
+}
+
+func TestOpenNotRecipient(t *testing.T) {
+	alice := mustIdentity(t)
+	bob := mustIdentity(t)
+	sealed := mustSeal(t, alice, "not for bob", alice)
+	if _, _, err := bob.Open(sealed); err != ErrNotRecipient {
+		t.Fatalf("Open by a non recipient = %v, want ErrNotRecipient", err)
+	}
+}
+
+func TestOpenPersistedIdentity(t *testing.T) {
+	alice := mustIdentity(t)
+	bob := mustIdentity(t)
+	sealed := mustSeal(t, alice, "hello", bob)
+	restored, err := ParseIdentity(bob.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, _, openErr := restored.Open(sealed); openErr != nil || text != "hello" {
+		t.Fatalf("Open with the parsed identity = %q, %v", text, openErr)
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	alice := mustIdentity(t)
+	bob := mustIdentity(t)
+	mallory := mustIdentity(t)
+	sealed := mustSeal(t, alice, "transfer 10 coins", bob)
+	tests := []struct {
+		name string
+		tamper func(envelope *Messages.E2EEnvelope)
+	}{
+		{"ciphertext", func(envelope *Messages.E2EEnvelope) {
+			envelope.Ciphertext[0] ^= 1
+		}},
+		{"truncated ciphertext", func(envelope *Messages.E2EEnvelope) {
+			envelope.Ciphertext = envelope.Ciphertext[:len(envelope.Ciphertext) - 1]
+		}},
+		{"nonce", func(envelope *Messages.E2EEnvelope) {
+			envelope.Nonce[0] ^= 1
+		}},
+		{"short nonce", func(envelope *Messages.E2EEnvelope) {
+			envelope.Nonce = envelope.Nonce[:4]
+		}},
+		{"wrapped key", func(envelope *Messages.E2EEnvelope) {
+			envelope.Keys[0].Wrapped[0] ^= 1
+		}},
+		//Claiming another sender changes the wrapping key and the authenticated data
+		{"sender key", func(envelope *Messages.E2EEnvelope) {
+			envelope.SenderKey = mallory.PublicKey()
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			envelope := decodeEnvelope(t, sealed)
+			test.tamper(envelope)
+			if text, _, err := bob.Open(resealEnvelope(t, envelope)); err == nil {
+				t.Fatalf("tampered message opened as %q", text)
+			}
+		})
+	}
+}
+
+func TestOpenMalformed(t *testing.T) {
+	alice := mustIdentity(t)
+	for _, sealed := range []string{
+		"hello",
+		SealedPrefix + "not base64!",
+		SealedPrefix + base64.StdEncoding.EncodeToString([]byte{0x0a, 0x05, 'a'}),
+	} {
+		if _, _, err := alice.Open(sealed); !errors.Is(err, ErrMalformedSealed) {
+			t.Errorf("Open(%q) = %v, want ErrMalformedSealed", sealed, err)
+		}
+	}
+}
+
+func TestKeyAnnouncement(t *testing.T) {
+	alice := mustIdentity(t)
+	publicKey, ok := ParseKeyAnnouncement(KeyAnnouncement(alice.PublicKey()))
+	if !ok || !bytes.Equal(publicKey, alice.PublicKey()) {
+		t.Fatalf("ParseKeyAnnouncement = %x, %v", publicKey, ok)
+	}
+	for _, text := range []string{"hello", KeyAnnouncementPrefix, KeyAnnouncementPrefix + "zz"} {
+		if _, ok := ParseKeyAnnouncement(text); ok {
+			t.Errorf("ParseKeyAnnouncement(%q) accepted", text)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	alice := mustIdentity(t)
+	bob := mustIdentity(t)
+	if Fingerprint(alice.PublicKey()) != Fingerprint(alice.PublicKey()) {
+		t.Error("fingerprint of the same key differs")
+	}
+	if Fingerprint(alice.PublicKey()) == Fingerprint(bob.PublicKey()) {
+		t.Error("different keys share a fingerprint")
+	}
+}
@@ -0,0 +1,77 @@
+/*
+	Decodes the Messages.Error bodies of "*Err" frames into Go errors
+ */
+
+package initchat
+
+import (
+	"../Messages"
+	"errors"
+	"github.com/golang/protobuf/proto"
+)
+
+//Codes the server sends in Messages.Error
+const (
+	CodeInvalidCredentials int32 = 1
+	CodeUsernameTaken int32 = 2
+	CodeGroupExists int32 = 3
+	CodeNotMember int32 = 4
+	CodeFileNotFound int32 = 5
+	CodeRateLimited int32 = 6
+	CodeCorruptFile int32 = 7
+	CodeCertificateRejected int32 = 8
+)
+
+var ErrInvalidCredentials = errors.New("invalid username or password")
+var ErrUsernameTaken = errors.New("username is already taken")
+var ErrGroupExists = errors.New("group already exists")
+var ErrNotMember = errors.New("not a member of that group")
+var ErrFileNotFound = errors.New("file not found")
+var ErrRateLimited = errors.New("too many requests, try again later")
+var ErrCorruptFile = errors.New("file is corrupted or truncated")
+var ErrCertificateRejected = errors.New("client certificate was not accepted")
+
+var codeErrors = map[int32]error{
+	CodeInvalidCredentials: ErrInvalidCredentials,
+	CodeUsernameTaken: ErrUsernameTaken,
+	CodeGroupExists: ErrGroupExists,
+	CodeNotMember: ErrNotMember,
+	CodeFileNotFound: ErrFileNotFound,
+	CodeRateLimited: ErrRateLimited,
+	CodeCorruptFile: ErrCorruptFile,
+	CodeCertificateRejected: ErrCertificateRejected,
+}
+
+//A request the server refused; errors.Is matches it against the Err value for its code
+type ServerError struct {
+	Request string
+	Code int32
+	Message string
+}
+
+func (err *ServerError) Error() string {
+	if err.Message != "" {
+		return err.Message
+	}
+	if known := err.Unwrap(); known != nil {
+		return known.Error()
+	}
+	return "server rejected " + err.Request
+}
+
+func (err *ServerError) Unwrap() error {
+	return codeErrors[err.Code]
+}
+
+//Builds the error for a response frame that wasn't the expected success type
+func serverError(request string, msg *Message) error {
+	errMsg := Messages.Error{}
+	if parseErr := proto.Unmarshal(msg.body, &errMsg); parseErr != nil {
+		return &ServerError{Request: request}
+	}
+	return &ServerError{
+		Request: request,
+		Code: errMsg.Code,
+		Message: errMsg.Message,
+	}
+}
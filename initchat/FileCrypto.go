@@ -0,0 +1,313 @@
+/*
+	Encrypts files with a random per-file key before upload and decrypts them after download
+ */
+
+package initchat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//Prefix of the sealed text that hands a file's key to the group
+const FileKeyPrefix = "~e2efile1:"
+
+//Plain text bytes per encrypted chunk, each chunk gets its own authentication tag
+const fileCryptoChunkSize = 64 * 1024
+
+var fileCryptoMagic = []byte("ICE1")
+
+//How many numbered names a download tries before giving up on finding a free one
+var maxNameAttempts = 1000
+
+//What a member needs to download and decrypt an encrypted upload
+type FileKey struct {
+	FileID string `json:"fileID"`
+	Name string `json:"name"`
+	Size uint64 `json:"size"`
+	Key []byte `json:"key"`
+}
+
+//Text to seal and send to the group so members can decrypt the file
+func FileKeyText(fileKey FileKey) string {
+	data, _ := json.Marshal(fileKey)
+	return FileKeyPrefix + base64.StdEncoding.EncodeToString(data)
+}
+
+//Returns the file key if the opened text hands one over
+func ParseFileKeyText(text string) (*FileKey, bool) {
+	if !strings.HasPrefix(text, FileKeyPrefix) {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(text[len(FileKeyPrefix):])
+	if err != nil {
+		return nil, false
+	}
+	fileKey := FileKey{}
+	if parseErr := json.Unmarshal(data, &fileKey); parseErr != nil || fileKey.FileID == "" || len(fileKey.Key) != 32 {
+		return nil, false
+	}
+	return &fileKey, true
+}
+
+//Nonce of a chunk from its position, the last byte marks the final chunk so truncation is detected
+func chunkNonce(index uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], index)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+//Writes src to dst as a header followed by authenticated chunks
+func EncryptFile(src io.Reader, dst io.Writer, key []byte) error {
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	if _, writeErr := dst.Write(fileCryptoMagic); writeErr != nil {
+		return writeErr
+	}
+	//Reading one chunk ahead tells whether the current one is the last
+	current := make([]byte, fileCryptoChunkSize)
+	next := make([]byte, fileCryptoChunkSize)
+	n, readErr := io.ReadFull(src, current)
+	for index := uint64(0); ; index++ {
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return readErr
+		}
+		final := readErr != nil
+		nextN := 0
+		var nextErr error
+		if !final {
+			nextN, nextErr = io.ReadFull(src, next)
+			if nextErr == io.EOF {
+				final = true
+			} else if nextErr != nil && nextErr != io.ErrUnexpectedEOF {
+				return nextErr
+			}
+		}
+		sealed := aead.Seal(nil, chunkNonce(index, final), current[:n], fileCryptoMagic)
+		if _, writeErr := dst.Write(sealed); writeErr != nil {
+			return writeErr
+		}
+		if final {
+			return nil
+		}
+		current, next = next, current
+		n, readErr = nextN, nextErr
+	}
+}
+
+//Decrypts what EncryptFile wrote, failing on any altered, reordered or missing chunk
+func DecryptFile(src io.Reader, dst io.Writer, key []byte) error {
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	magic := make([]byte, len(fileCryptoMagic))
+	if _, readErr := io.ReadFull(src, magic); readErr != nil || string(magic) != string(fileCryptoMagic) {
+		return errors.New("not an encrypted initchat file")
+	}
+	sealedSize := fileCryptoChunkSize + aead.Overhead()
+	current := make([]byte, sealedSize)
+	next := make([]byte, sealedSize)
+	n, readErr := io.ReadFull(src, current)
+	for index := uint64(0); ; index++ {
+		if readErr == io.EOF {
+			return errors.New("encrypted file is truncated")
+		}
+		if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			return readErr
+		}
+		final := readErr == io.ErrUnexpectedEOF
+		nextN := 0
+		var nextErr error
+		if !final {
+			nextN, nextErr = io.ReadFull(src, next)
+			if nextErr == io.EOF {
+				final = true
+			}
+		}
+		plain, openErr := aead.Open(nil, chunkNonce(index, final), current[:n], fileCryptoMagic)
+		if openErr != nil {
+			return errors.New("chunk " + strconv.FormatUint(index, 10) + " failed authentication")
+		}
+		if _, writeErr := dst.Write(plain); writeErr != nil {
+			return writeErr
+		}
+		if final {
+			return nil
+		}
+		current, next = next, current
+		n, readErr = nextN, nextErr
+	}
+}
+
+//Encrypted copies of uploads are kept in the user's cache directory until the upload finishes, so it
+//can resume. Their keys are only kept in memory, a copy whose key is lost is encrypted again.
+func encryptedUploadDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.New("no cache directory for encrypted uploads: " + err.Error())
+	}
+	dir := filepath.Join(cacheDir, "initchat-uploads")
+	if dirErr := os.MkdirAll(dir, 0700); dirErr != nil {
+		return "", dirErr
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", errors.New(dir + " is not a directory")
+	}
+	if ownerErr := checkOwner(info); ownerErr != nil {
+		return "", errors.New("refusing to keep encrypted uploads in " + dir + ": " + ownerErr.Error())
+	}
+	if info.Mode().Perm() & 0077 != 0 {
+		if chmodErr := os.Chmod(dir, 0700); chmodErr != nil {
+			return "", chmodErr
+		}
+	}
+	return dir, nil
+}
+
+//Encrypts the file with a new random key, or reuses the copy an interrupted attempt of this run left,
+//and uploads it. Returns the file ID and the key members need to decrypt it.
+func (client *Client) UploadEncrypted(ctx context.Context, filePath string, progress ProgressFunc) (string, []byte, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", nil, errors.New("Could not load file: " + err.Error())
+	}
+	dir, err := encryptedUploadDir()
+	if err != nil {
+		return "", nil, err
+	}
+	transferID := uploadTransferID(filePath, info)
+	encPath := filepath.Join(dir, transferID + ".enc")
+
+	client.uploadKeysMutex.Lock()
+	key := client.uploadKeys[transferID]
+	client.uploadKeysMutex.Unlock()
+	if _, encErr := os.Stat(encPath); key == nil || encErr != nil {
+		key, err = randomBytes(32)
+		if err != nil {
+			return "", nil, err
+		}
+		os.Remove(encPath)
+		if encryptErr := encryptToFile(filePath, encPath, key); encryptErr != nil {
+			os.Remove(encPath)
+			return "", nil, encryptErr
+		}
+		client.uploadKeysMutex.Lock()
+		client.uploadKeys[transferID] = key
+		client.uploadKeysMutex.Unlock()
+	}
+	fileID, err := client.Upload(ctx, encPath, progress)
+	if err != nil {
+		return "", nil, err
+	}
+	client.uploadKeysMutex.Lock()
+	delete(client.uploadKeys, transferID)
+	client.uploadKeysMutex.Unlock()
+	os.Remove(encPath)
+	return fileID, key, nil
+}
+
+func encryptToFile(srcPath string, dstPath string, key []byte) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if encryptErr := EncryptFile(src, dst, key); encryptErr != nil {
+		dst.Close()
+		return encryptErr
+	}
+	return dst.Close()
+}
+
+//Downloads an encrypted upload and decrypts it into dir under the name it was shared with.
+//Nothing is written there unless every chunk is authenticated, and the encrypted copy is removed either way.
+func (client *Client) DownloadEncrypted(ctx context.Context, fileKey FileKey, dir string, progress ProgressFunc) (string, error) {
+	encPath, err := client.Download(ctx, fileKey.FileID, dir, progress)
+	if err != nil {
+		return "", err
+	}
+	//The name comes from the sender, it must not pick where the file lands
+	name := filepath.Base(fileKey.Name)
+	if !isPlainFileName(name) {
+		sum := sha256.Sum256([]byte(fileKey.FileID))
+		name = hex.EncodeToString(sum[:8])
+	}
+	src, err := os.Open(encPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := ioutil.TempFile(dir, "." + name + ".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := dst.Name()
+	defer os.Remove(tmpPath)
+	if chmodErr := dst.Chmod(0644); chmodErr != nil {
+		dst.Close()
+		return "", chmodErr
+	}
+	if decryptErr := DecryptFile(src, dst, fileKey.Key); decryptErr != nil {
+		dst.Close()
+		//A copy that fails authentication can't be resumed into a good one
+		src.Close()
+		os.Remove(encPath)
+		return "", &IntegrityError{FileID: fileKey.FileID, Reason: "decryption failed, " + decryptErr.Error()}
+	}
+	if closeErr := dst.Close(); closeErr != nil {
+		return "", closeErr
+	}
+	filePath, err := linkFreeName(tmpPath, dir, name)
+	if err != nil {
+		return "", err
+	}
+	src.Close()
+	os.Remove(encPath)
+	return filePath, nil
+}
+
+//Links path into dir under name, or under "name (2).ext" and so on when that is taken, never replacing a file
+func linkFreeName(path string, dir string, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; i <= maxNameAttempts; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+		}
+		filePath := filepath.Join(dir, candidate)
+		err := os.Link(path, filePath)
+		if err == nil {
+			return filePath, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+	return "", errors.New("No free name for " + name + " in " + dir)
+}
@@ -0,0 +1,204 @@
+package initchat
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//Size of a full chunk after the magic, its plain text plus the authentication tag
+const sealedChunkSize = fileCryptoChunkSize + 16
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustEncrypt(t *testing.T, plain []byte, key []byte) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	if err := EncryptFile(bytes.NewReader(plain), &sealed, key); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+//Keeps encrypted uploads of the test out of the real cache directory
+func useCacheDir(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, "cache"))
+	t.Setenv("LocalAppData", filepath.Join(home, "cache"))
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(cacheDir, "initchat-uploads")
+}
+
+func TestFileCryptoRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"small", 100},
+		{"one chunk", fileCryptoChunkSize},
+		{"three chunks", 3 * fileCryptoChunkSize},
+		{"partial last chunk", 2 * fileCryptoChunkSize + 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plain := make([]byte, test.size)
+			if _, err := rand.Read(plain); err != nil {
+				t.Fatal(err)
+			}
+			key := testKey(t)
+			sealed := mustEncrypt(t, plain, key)
+			chunks := (test.size + fileCryptoChunkSize - 1) / fileCryptoChunkSize
+			if chunks == 0 {
+				chunks = 1
+			}
+			if want := len(fileCryptoMagic) + test.size + chunks * 16; len(sealed) != want {
+				t.Errorf("encrypted size = %d, want %d", len(sealed), want)
+			}
+			var opened bytes.Buffer
+			if err := DecryptFile(bytes.NewReader(sealed), &opened, key); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(opened.Bytes(), plain) {
+				t.Error("decrypted file differs from the original")
+			}
+		})
+	}
+}
+
+func TestFileCryptoRejects(t *testing.T) {
+	key := testKey(t)
+	plain := make([]byte, 3 * fileCryptoChunkSize)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+	sealed := mustEncrypt(t, plain, key)
+	exactMultiple := mustEncrypt(t, plain[:2 * fileCryptoChunkSize], key)
+	chunk := func(data []byte, index int) []byte {
+		start := len(fileCryptoMagic) + index * sealedChunkSize
+		end := start + sealedChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		return data[start:end]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(append([][]byte{fileCryptoMagic}, parts...), nil)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		key []byte
+	}{
+		{"wrong key", sealed, testKey(t)},
+		{"no magic", sealed[len(fileCryptoMagic):], key},
+		{"magic only", fileCryptoMagic, key},
+		{"flipped bit", func() []byte {
+			data := append([]byte(nil), sealed...)
+			data[len(data) / 2] ^= 1
+			return data
+		}(), key},
+		{"truncated mid chunk", sealed[:len(sealed) - 100], key},
+		{"truncated at chunk boundary", sealed[:len(fileCryptoMagic) + 2 * sealedChunkSize], key},
+		{"reordered chunks", join(chunk(sealed, 1), chunk(sealed, 0), chunk(sealed, 2)), key},
+		{"duplicated chunk", join(chunk(sealed, 0), chunk(sealed, 0), chunk(sealed, 1), chunk(sealed, 2)), key},
+		{"dropped final chunk", join(chunk(sealed, 0), chunk(sealed, 1)), key},
+		{"dropped final chunk of exact multiple", join(chunk(exactMultiple, 0)), key},
+		{"final chunk moved first", join(chunk(sealed, 2)), key},
+		{"appended chunk", append(append([]byte(nil), sealed...), chunk(sealed, 2)...), key},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var opened bytes.Buffer
+			if err := DecryptFile(bytes.NewReader(test.data), &opened, test.key); err == nil {
+				t.Fatalf("decrypted %d bytes", opened.Len())
+			}
+		})
+	}
+}
+
+//The encrypted copy lives in a private directory and nothing is left behind once the upload finishes
+func TestEncryptedUploadCache(t *testing.T) {
+	dir := useCacheDir(t)
+	server, client := newTestClient(t)
+	server.ServeFiles()
+	path, _ := writeTestFile(t, "secret.pdf", 100)
+
+	if _, _, err := client.UploadEncrypted(testContext(t), path, nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm & 0077 != 0 {
+		t.Errorf("upload directory mode = %v, want private", perm)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("upload left %d files behind", len(entries))
+	}
+}
+
+//A failed upload keeps the encrypted copy for a retry but never writes its key to disk
+func TestEncryptedUploadFailure(t *testing.T) {
+	dir := useCacheDir(t)
+	server, client := newTestClient(t)
+	server.ServeFiles()
+	server.Expect("uploadStart").RespondError("uploadErr", CodeRateLimited, "")
+	path, data := writeTestFile(t, "secret.pdf", 100)
+
+	if _, _, err := client.UploadEncrypted(testContext(t), path, nil); err == nil {
+		t.Fatal("upload succeeded")
+	}
+	client.uploadKeysMutex.Lock()
+	retryKeys := len(client.uploadKeys)
+	client.uploadKeysMutex.Unlock()
+	if retryKeys != 1 {
+		t.Errorf("%d keys kept for a retry, want 1", retryKeys)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("failed upload left %d files, want the encrypted copy", len(entries))
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".enc" {
+			t.Errorf("failed upload left %s behind", entry.Name())
+			continue
+		}
+		if entry.Mode().Perm() & 0077 != 0 {
+			t.Errorf("%s has mode %v", entry.Name(), entry.Mode().Perm())
+		}
+	}
+
+	fileID, key, err := client.UploadEncrypted(testContext(t), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := server.File(fileID)
+	var opened bytes.Buffer
+	if decryptErr := DecryptFile(bytes.NewReader(stored), &opened, key); decryptErr != nil || !bytes.Equal(opened.Bytes(), data) {
+		t.Fatalf("retried upload does not decrypt with its key: %v", decryptErr)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("retried upload left %d files behind", len(entries))
+	}
+}
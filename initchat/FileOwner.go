@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+/*
+	Checks who owns a file on systems with Unix permissions
+ */
+
+package initchat
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+//Fails unless the current user owns the file, so no one else could have planted or read what it holds
+func checkOwner(info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.New("owner is unknown")
+	}
+	if int(stat.Uid) != os.Getuid() {
+		return errors.New("owned by user " + strconv.FormatUint(uint64(stat.Uid), 10) + " instead of the current user")
+	}
+	return nil
+}
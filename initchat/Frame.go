@@ -0,0 +1,163 @@
+/*
+	Encodes and decodes frames: a big endian header length, a Header and the body it describes.
+	Decoding never trusts the lengths it reads beyond the configured limits.
+ */
+
+package initchat
+
+import (
+	"../Messages"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"strconv"
+)
+
+//Largest serialized Header accepted, it only holds a type ID and two integers
+var MaxHeaderSize = 1024
+
+//Largest body accepted or sent, far above a transfer chunk or a group's history
+var MaxBodySize = 16 * 1024 * 1024
+
+//Longest type ID accepted, the server's are short names such as getInvites
+const maxTypeIDLength = 64
+
+var ErrFrameTooLarge = errors.New("frame exceeds the size limit")
+var ErrMalformedFrame = errors.New("malformed frame")
+
+//Why a frame could not be decoded or encoded, matched by errors.Is against ErrFrameTooLarge,
+//ErrMalformedFrame or io.ErrUnexpectedEOF
+type FrameError struct {
+	//Part of the frame that was wrong: pre-header, header or body
+	Part string
+	Reason string
+	Err error
+}
+
+func (err *FrameError) Error() string {
+	return "frame " + err.Part + ": " + err.Reason
+}
+
+func (err *FrameError) Unwrap() error {
+	return err.Err
+}
+
+//Serializes one frame, refusing ones the other side would reject as too large
+func EncodeFrame(typeID string, requestID uint32, body []byte) ([]byte, error) {
+	if len(body) > MaxBodySize {
+		return nil, &FrameError{
+			Part: "body",
+			Reason: strconv.Itoa(len(body)) + " bytes is over the limit of " + strconv.Itoa(MaxBodySize),
+			Err: ErrFrameTooLarge,
+		}
+	}
+	if typeID == "" || len(typeID) > maxTypeIDLength {
+		return nil, &FrameError{Part: "header", Reason: "invalid type ID \"" + typeID + "\"", Err: ErrMalformedFrame}
+	}
+	header := &Messages.Header{Id: typeID, Length: int32(len(body)), RequestID: requestID}
+	headerData, err := proto.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if len(headerData) > MaxHeaderSize {
+		return nil, &FrameError{
+			Part: "header",
+			Reason: strconv.Itoa(len(headerData)) + " bytes is over the limit of " + strconv.Itoa(MaxHeaderSize),
+			Err: ErrFrameTooLarge,
+		}
+	}
+	data := make([]byte, PreHeaderLength, PreHeaderLength + len(headerData) + len(body))
+	binary.BigEndian.PutUint16(data, uint16(len(headerData)))
+	data = append(data, headerData...)
+	return append(data, body...), nil
+}
+
+//Reads one frame. A clean end of stream between frames returns io.EOF,
+//anything else that stops a frame from being read returns a FrameError.
+func ReadFrame(reader io.Reader) (*Message, error) {
+	preHeaderData := make([]byte, PreHeaderLength)
+	if n, err := io.ReadFull(reader, preHeaderData); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, truncated("pre-header", n, PreHeaderLength, err)
+	}
+	headerSize := int(binary.BigEndian.Uint16(preHeaderData))
+	if headerSize == 0 {
+		return nil, &FrameError{Part: "pre-header", Reason: "header length is zero", Err: ErrMalformedFrame}
+	}
+	if headerSize > MaxHeaderSize {
+		return nil, &FrameError{
+			Part: "pre-header",
+			Reason: "header length " + strconv.Itoa(headerSize) + " is over the limit of " + strconv.Itoa(MaxHeaderSize),
+			Err: ErrFrameTooLarge,
+		}
+	}
+
+	headerData := make([]byte, headerSize)
+	if n, err := io.ReadFull(reader, headerData); err != nil {
+		return nil, truncated("header", n, headerSize, err)
+	}
+	header := &Messages.Header{}
+	if parseErr := proto.Unmarshal(headerData, header); parseErr != nil {
+		return nil, &FrameError{Part: "header", Reason: "could not be parsed: " + parseErr.Error(), Err: ErrMalformedFrame}
+	}
+	typeID := header.GetId()
+	if typeID == "" || len(typeID) > maxTypeIDLength {
+		return nil, &FrameError{Part: "header", Reason: "invalid type ID \"" + typeID + "\"", Err: ErrMalformedFrame}
+	}
+	bodySize := header.GetLength()
+	if bodySize < 0 {
+		return nil, &FrameError{
+			Part: "header",
+			Reason: typeID + " has negative body length " + strconv.FormatInt(int64(bodySize), 10),
+			Err: ErrMalformedFrame,
+		}
+	}
+	if int64(bodySize) > int64(MaxBodySize) {
+		return nil, &FrameError{
+			Part: "header",
+			Reason: typeID + " body length " + strconv.FormatInt(int64(bodySize), 10) + " is over the limit of " + strconv.Itoa(MaxBodySize),
+			Err: ErrFrameTooLarge,
+		}
+	}
+
+	bodyData, n, err := readBody(reader, int(bodySize))
+	if err != nil {
+		return nil, truncated(typeID + " body", n, int(bodySize), err)
+	}
+	return &Message{typeID: typeID, requestID: header.GetRequestID(), body: bodyData}, nil
+}
+
+//Bodies up to this size are allocated at once, larger ones grow as their data arrives
+const bodyPreallocSize = 64 * 1024
+
+//Reads size bytes without allocating more than has arrived, so a peer claiming a huge body
+//and then stalling doesn't get the memory for it
+func readBody(reader io.Reader, size int) ([]byte, int, error) {
+	if size <= bodyPreallocSize {
+		body := make([]byte, size)
+		n, err := io.ReadFull(reader, body)
+		return body, n, err
+	}
+	var buffer bytes.Buffer
+	n, err := buffer.ReadFrom(io.LimitReader(reader, int64(size)))
+	if err == nil && int(n) < size {
+		err = io.ErrUnexpectedEOF
+	}
+	return buffer.Bytes(), int(n), err
+}
+
+//Error for a stream that ended or failed partway through a frame
+func truncated(part string, got int, want int, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &FrameError{
+			Part: part,
+			Reason: "truncated after " + strconv.Itoa(got) + " of " + strconv.Itoa(want) + " bytes",
+			Err: io.ErrUnexpectedEOF,
+		}
+	}
+	return &FrameError{Part: part, Reason: err.Error(), Err: err}
+}
@@ -0,0 +1,195 @@
+package initchat
+
+import (
+	"../Messages"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"testing"
+)
+
+func mustMarshal(t testing.TB, msg proto.Message) []byte {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func mustEncode(t testing.TB, typeID string, requestID uint32, body []byte) []byte {
+	data, err := EncodeFrame(typeID, requestID, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+//Frame with a hand built header, for lengths EncodeFrame would never write
+func rawFrame(t testing.TB, header *Messages.Header, body []byte) []byte {
+	headerData := mustMarshal(t, header)
+	data := make([]byte, PreHeaderLength)
+	binary.BigEndian.PutUint16(data, uint16(len(headerData)))
+	return append(append(data, headerData...), body...)
+}
+
+//Frames as the client and server exchange them
+func realFrames(t testing.TB) [][]byte {
+	return [][]byte{
+		mustEncode(t, "login", 1, mustMarshal(t, &Messages.LoginReq{Username: "alice", Password: "secret"})),
+		mustEncode(t, "auth", 1, mustMarshal(t, &Messages.AuthResp{Token: "token", ExpireTime: 1700000000})),
+		mustEncode(t, "authErr", 2, mustMarshal(t, &Messages.Error{Code: CodeInvalidCredentials, Message: "wrong password"})),
+		mustEncode(t, "getGroups", 3, nil),
+		mustEncode(t, "group", 4, mustMarshal(t, &Messages.GroupResp{Messages: []*Messages.TextMessage{
+			{Username: "bob", Message: "hi", Time: 1700000000},
+		}})),
+		mustEncode(t, "message", 0, mustMarshal(t, &Messages.TextMessage{Username: "bob", Message: "hello there", Time: 1700000001})),
+		mustEncode(t, "getInvites", 5, mustMarshal(t, &Messages.InvitesResp{Invites: []*Messages.InvitesResp_Invite{
+			{InviteID: "i1", FromUsername: "carol", GroupName: "team"},
+		}})),
+		mustEncode(t, "fileChunk", 6, mustMarshal(t, &Messages.FileChunk{Offset: 65536, Data: bytes.Repeat([]byte{0xab}, 300)})),
+		mustEncode(t, "leaveGroup", 0, nil),
+	}
+}
+
+func TestReadFrameRoundTrip(t *testing.T) {
+	var stream bytes.Buffer
+	frames := realFrames(t)
+	for _, frame := range frames {
+		stream.Write(frame)
+	}
+	for i := range frames {
+		msg, err := ReadFrame(&stream)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if again := mustEncode(t, msg.typeID, msg.requestID, msg.body); !bytes.Equal(again, frames[i]) {
+			t.Errorf("frame %d re-encoded differently", i)
+		}
+	}
+	if _, err := ReadFrame(&stream); err != io.EOF {
+		t.Errorf("ReadFrame() at the end of the stream = %v, want io.EOF", err)
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	login := mustEncode(t, "login", 1, mustMarshal(t, &Messages.LoginReq{Username: "alice"}))
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"negative length", rawFrame(t, &Messages.Header{Id: "message", Length: -5}, nil), ErrMalformedFrame},
+		{"huge length", rawFrame(t, &Messages.Header{Id: "message", Length: 1 << 30}, nil), ErrFrameTooLarge},
+		{"length just over the limit", rawFrame(t, &Messages.Header{Id: "message", Length: int32(MaxBodySize + 1)}, nil), ErrFrameTooLarge},
+		{"header over the limit", []byte{0xff, 0xff}, ErrFrameTooLarge},
+		{"zero header length", []byte{0, 0, 1, 2, 3}, ErrMalformedFrame},
+		{"unparsable header", []byte{0, 3, 0xff, 0xff, 0xff}, ErrMalformedFrame},
+		{"missing type ID", rawFrame(t, &Messages.Header{Length: 0, RequestID: 3}, nil), ErrMalformedFrame},
+		{"type ID too long", rawFrame(t, &Messages.Header{Id: string(bytes.Repeat([]byte{'a'}, 65))}, nil), ErrMalformedFrame},
+		{"half a pre-header", []byte{0}, io.ErrUnexpectedEOF},
+		{"truncated header", login[:4], io.ErrUnexpectedEOF},
+		{"truncated body", login[:len(login) - 1], io.ErrUnexpectedEOF},
+		{"truncated large body", rawFrame(t, &Messages.Header{Id: "fileChunk", Length: 1 << 20}, make([]byte, 1000)), io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := ReadFrame(bytes.NewReader(test.data))
+			if !errors.Is(err, test.want) {
+				t.Fatalf("ReadFrame() = %v, %v, want %v", msg, err, test.want)
+			}
+			var frameErr *FrameError
+			if !errors.As(err, &frameErr) || frameErr.Reason == "" {
+				t.Errorf("ReadFrame() error %v is not a descriptive FrameError", err)
+			}
+		})
+	}
+}
+
+func TestEncodeFrameLimits(t *testing.T) {
+	if _, err := EncodeFrame("fileChunk", 1, make([]byte, MaxBodySize + 1)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("EncodeFrame() of an oversized body = %v, want ErrFrameTooLarge", err)
+	}
+	if _, err := EncodeFrame("", 1, nil); !errors.Is(err, ErrMalformedFrame) {
+		t.Errorf("EncodeFrame() without a type ID = %v, want ErrMalformedFrame", err)
+	}
+}
+
+func TestSendRefusesOversizedBody(t *testing.T) {
+	_, client := newTestClient(t)
+	err := client.send(testContext(t), "textMsg", make([]byte, MaxBodySize + 1))
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("send() of an oversized body = %v, want ErrFrameTooLarge", err)
+	}
+}
+
+//Decoding arbitrary bytes must fail with a FrameError or give a frame that encodes back to itself
+func FuzzReadFrame(f *testing.F) {
+	for _, frame := range realFrames(f) {
+		f.Add(frame)
+		f.Add(frame[:len(frame) / 2])
+	}
+	f.Add(rawFrame(f, &Messages.Header{Id: "message", Length: -1}, nil))
+	f.Add(rawFrame(f, &Messages.Header{Id: "message", Length: 2147483647}, nil))
+	f.Add(rawFrame(f, &Messages.Header{Id: "message", Length: 4}, []byte{1, 2}))
+	f.Add([]byte{0xff, 0xff, 0})
+	f.Add([]byte{0, 0})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bytes.NewReader(data)
+		msg, err := ReadFrame(reader)
+		if err != nil {
+			var frameErr *FrameError
+			if err != io.EOF && !errors.As(err, &frameErr) {
+				t.Fatalf("ReadFrame() returned a bare error: %v", err)
+			}
+			if err == io.EOF && len(data) != 0 {
+				t.Fatalf("ReadFrame() returned io.EOF for %d bytes", len(data))
+			}
+			return
+		}
+		if len(msg.body) > MaxBodySize {
+			t.Fatalf("ReadFrame() accepted a %d byte body", len(msg.body))
+		}
+		encoded, err := EncodeFrame(msg.typeID, msg.requestID, msg.body)
+		if err != nil {
+			t.Fatalf("EncodeFrame() of a decoded frame: %v", err)
+		}
+		again, err := ReadFrame(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("ReadFrame() of a re-encoded frame: %v", err)
+		}
+		if again.typeID != msg.typeID || again.requestID != msg.requestID || !bytes.Equal(again.body, msg.body) {
+			t.Fatalf("re-encoded frame decoded to %q %d, want %q %d", again.typeID, again.requestID, msg.typeID, msg.requestID)
+		}
+	})
+}
+
+//Every frame EncodeFrame accepts decodes back to the same fields and uses up exactly its bytes
+func FuzzEncodeFrame(f *testing.F) {
+	f.Add("login", uint32(1), mustMarshal(f, &Messages.LoginReq{Username: "alice", Password: "secret"}))
+	f.Add("getGroups", uint32(0), []byte(nil))
+	f.Add("message", uint32(4294967295), []byte("\x00\xff"))
+	f.Add("", uint32(7), []byte("no type"))
+
+	f.Fuzz(func(t *testing.T, typeID string, requestID uint32, body []byte) {
+		encoded, err := EncodeFrame(typeID, requestID, body)
+		if err != nil {
+			//Refused sizes and type IDs, or type IDs that aren't valid UTF-8 and can't be marshalled
+			return
+		}
+		reader := bytes.NewReader(encoded)
+		msg, err := ReadFrame(reader)
+		if err != nil {
+			t.Fatalf("ReadFrame() of an encoded frame: %v", err)
+		}
+		if msg.typeID != typeID || msg.requestID != requestID || !bytes.Equal(msg.body, body) {
+			t.Fatalf("decoded %q %d %x, want %q %d %x", msg.typeID, msg.requestID, msg.body, typeID, requestID, body)
+		}
+		if reader.Len() != 0 {
+			t.Fatalf("%d bytes left after the frame", reader.Len())
+		}
+	})
+}
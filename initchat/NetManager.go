@@ -0,0 +1,442 @@
+package initchat
+
+import (
+	"../Messages"
+	"context"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"log"
+	"strings"
+	"sync"
+)
+
+var errNoToken = errors.New("No session token")
+var errNoPassword = errors.New("Session was resumed from a token, log in again to renew it")
+var errNoCertUser = errors.New("Server did not say which user the certificate belongs to")
+
+func (client *Client) handleMessage(message *Message) {
+	if message.requestID == 0 {
+		client.dispatcher.Dispatch(message)
+		return
+	}
+	//A late answer to a request that gave up must not reach another request waiting on the same type
+	if !client.routeResponse(message) {
+		log.Println("Dropped response to finished request ", message.requestID, ": ", message.typeID)
+	}
+}
+
+func logUnhandled(message *Message) {
+	if strings.HasSuffix(message.typeID, "Err") {
+		log.Println("Server error: ", serverError(strings.TrimSuffix(message.typeID, "Err"), message))
+		return
+	}
+	log.Println("No type handler for id: ", message.typeID)
+}
+
+func (client *Client) runNetEvents() {
+	for {
+		select {
+		case msg, more := <- client.recvChannel:
+			if !more {
+				return
+			}
+			client.handleMessage(msg)
+		case disconnected, more := <- client.disconnectChannel:
+			if !more {
+				return
+			}
+			if disconnected.isClosed() {
+				disconnected.setConnection(nil)
+				disconnected.setState(Disconnected)
+				continue
+			}
+			log.Println("DISCONNECTED")
+			go disconnected.reconnect()
+		}
+	}
+}
+
+//Sends a request and waits for its response. Responses echoing the request ID are routed
+//to this caller only; servers that don't echo it are matched by response type instead.
+func (client *Client) request(ctx context.Context, typeID string, body []byte, respTypes ...string) (*Message, error) {
+	done := client.done()
+	if done == nil {
+		return nil, ErrDisconnected
+	}
+	fallbackSub := client.dispatcher.Register(respTypes...)
+	defer fallbackSub.Unregister()
+	requestID, respChannel := client.addPending()
+	defer client.finishRequest(requestID)
+
+	reqMsg := Message{
+		typeID: typeID,
+		requestID: requestID,
+		body: body,
+	}
+	if sendErr := client.enqueue(ctx, &reqMsg); sendErr != nil {
+		return nil, sendErr
+	}
+	select {
+	case resp := <-respChannel:
+		return resp, nil
+	case resp := <-fallbackSub.Messages():
+		return resp, nil
+	case <-done:
+		return nil, ErrDisconnected
+	case <-ctx.Done():
+		return nil, contextErr(ctx, typeID)
+	}
+}
+
+func (client *Client) SignUp(ctx context.Context, username string, password string) error {
+	signUpMsg := Messages.SignUpReq{
+		Username: username,
+		Password: password,
+	}
+	signUpData, err := proto.Marshal(&signUpMsg)
+	if err != nil {
+		return err
+	}
+
+	authMsg, reqErr := client.request(ctx, "signUp", signUpData, "auth", "authErr")
+	if reqErr != nil {
+		return reqErr
+	}
+	if authMsg.typeID != "auth" {
+		return serverError("signUp", authMsg)
+	}
+	authResp := Messages.AuthResp{}
+	proto.Unmarshal(authMsg.body, &authResp)
+	client.session.setAuth(username, password, authResp.Token, authResp.ExpireTime)
+	return nil
+}
+
+func (client *Client) Login(ctx context.Context, username string, password string) error {
+	loginMsg := Messages.LoginReq{
+		Username: username,
+		Password: password,
+	}
+	loginData, err := proto.Marshal(&loginMsg)
+	if err != nil {
+		return err
+	}
+
+	authMsg, reqErr := client.request(ctx, "login", loginData, "auth", "authErr")
+	if reqErr != nil {
+		return reqErr
+	}
+	if authMsg.typeID != "auth" {
+		return serverError("login", authMsg)
+	}
+	authResp := Messages.AuthResp{}
+	proto.Unmarshal(authMsg.body, &authResp)
+	client.session.setAuth(username, password, authResp.Token, authResp.ExpireTime)
+	return nil
+}
+
+//Logs in as the user the TLS client certificate was issued to, without a password.
+//username may be empty to take whichever user the server maps the certificate to.
+func (client *Client) LoginWithCertificate(ctx context.Context, username string) error {
+	certLoginMsg := Messages.CertLoginReq{
+		Username: username,
+	}
+	certLoginData, err := proto.Marshal(&certLoginMsg)
+	if err != nil {
+		return err
+	}
+
+	authMsg, reqErr := client.request(ctx, "certLogin", certLoginData, "auth", "authErr")
+	if reqErr != nil {
+		return reqErr
+	}
+	if authMsg.typeID != "auth" {
+		return serverError("certLogin", authMsg)
+	}
+	authResp := Messages.AuthResp{}
+	proto.Unmarshal(authMsg.body, &authResp)
+	if authResp.Username != "" {
+		username = authResp.Username
+	}
+	if username == "" {
+		return errNoCertUser
+	}
+	client.session.setCertificateAuth(username, authResp.Token, authResp.ExpireTime)
+	return nil
+}
+
+//Authenticates as username with the token from a previous AuthResp instead of a password
+func (client *Client) Resume(ctx context.Context, username string, token string) error {
+	resumeMsg := Messages.ResumeReq{
+		Token: token,
+	}
+	resumeData, err := proto.Marshal(&resumeMsg)
+	if err != nil {
+		return err
+	}
+
+	authMsg, reqErr := client.request(ctx, "resume", resumeData, "auth", "authErr")
+	if reqErr != nil {
+		return reqErr
+	}
+	if authMsg.typeID != "auth" {
+		return serverError("resume", authMsg)
+	}
+	authResp := Messages.AuthResp{}
+	proto.Unmarshal(authMsg.body, &authResp)
+	if authResp.Token == "" {
+		authResp.Token = token
+	}
+	//Keep how the same user logged in, so the session can still be renewed
+	sessionUser, password, _, _ := client.session.snapshot()
+	if sessionUser != username {
+		password = ""
+	} else if client.session.usesCertificate() {
+		client.session.setCertificateAuth(username, authResp.Token, authResp.ExpireTime)
+		return nil
+	}
+	client.session.setAuth(username, password, authResp.Token, authResp.ExpireTime)
+	return nil
+}
+
+//Logs in again with the password or certificate of this session to get a fresh token before the old one expires
+func (client *Client) RenewSession(ctx context.Context) error {
+	username, password, _, _ := client.session.snapshot()
+	if client.session.usesCertificate() {
+		return client.LoginWithCertificate(ctx, username)
+	}
+	if password == "" {
+		return errNoPassword
+	}
+	return client.Login(ctx, username, password)
+}
+
+func (client *Client) CreateGroup(ctx context.Context, groupName string) (*Messages.GroupResp, error) {
+	createGroupMsg := Messages.CreateGroupReq{
+		GroupName: groupName,
+	}
+	createGroupData, err := proto.Marshal(&createGroupMsg)
+	if err != nil {
+		return nil, err
+	}
+	groupMsg, reqErr := client.request(ctx, "createGroup", createGroupData, "group", "createGroupErr")
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if groupMsg.typeID != "group" {
+		return nil, serverError("createGroup", groupMsg)
+	}
+	group := Messages.GroupResp{}
+	proto.Unmarshal(groupMsg.body, &group)
+	client.session.setGroup(groupName)
+	return &group, nil
+}
+
+//Text messages pushed by the server for the group the client is in
+type TextStream struct {
+	sub *Subscription
+	messages chan *Messages.TextMessage
+	done chan struct{}
+	closeOnce sync.Once
+}
+
+//Closed once the stream is closed
+func (stream *TextStream) Messages() <-chan *Messages.TextMessage {
+	return stream.messages
+}
+
+//Stops the stream even if nobody reads the messages still buffered, safe to call more than once
+func (stream *TextStream) Close() {
+	stream.closeOnce.Do(func() {
+		close(stream.done)
+	})
+	stream.sub.Unregister()
+}
+
+func (client *Client) TextMessages() *TextStream {
+	stream := &TextStream{
+		sub: client.dispatcher.Register("message"),
+		messages: make(chan *Messages.TextMessage, DispatchBufferSize),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(stream.messages)
+		for message := range stream.sub.Messages() {
+			//Messages still buffered in the subscription are dropped once the stream is closed
+			select {
+			case <-stream.done:
+				return
+			default:
+			}
+			textMsg := Messages.TextMessage{}
+			parseErr := proto.Unmarshal(message.body, &textMsg)
+			if parseErr != nil {
+				log.Println("PARSE ERR: ", parseErr)
+				continue
+			}
+			select {
+			case stream.messages <- &textMsg:
+			case <-stream.done:
+				return
+			}
+		}
+	}()
+	return stream
+}
+
+func (client *Client) RefreshGroup(ctx context.Context) (*Messages.GroupResp, error) {
+	groupMsg, reqErr := client.request(ctx, "refreshGroup", nil, "group")
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	group := Messages.GroupResp{}
+	proto.Unmarshal(groupMsg.body, &group)
+	return &group, nil
+}
+
+func (client *Client) SendText(ctx context.Context, contents string) error {
+	textMsg := Messages.TextMessageReq{
+		Message: contents,
+	}
+	textData, err:= proto.Marshal(&textMsg)
+	if err != nil {
+		return err
+	}
+	return client.send(ctx, "textMsg", textData)
+}
+
+func (client *Client) LeaveGroup(ctx context.Context) error {
+	client.session.setGroup("")
+	return client.send(ctx, "leaveGroup", nil)
+}
+
+func (client *Client) SearchUsers(ctx context.Context, namePrefix string) ([]string, error) {
+	searchUserReq := Messages.UserSearchReq{
+		UsernamePrefix: namePrefix,
+	}
+	reqData, serializeErr := proto.Marshal(&searchUserReq)
+	if serializeErr != nil {
+		return nil, serializeErr
+	}
+	respMsg, reqErr := client.request(ctx, "searchUsers", reqData, "userSearchResp", "userSearchErr")
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if respMsg.typeID != "userSearchResp" {
+		return nil, serverError("searchUsers", respMsg)
+	}
+	resp := Messages.UserSearchResp{}
+	proto.Unmarshal(respMsg.body, &resp)
+	return resp.Usernames, nil
+}
+
+func (client *Client) Invite(ctx context.Context, username string) error {
+	inviteUserReq := Messages.InviteReq{
+		Username: username,
+	}
+	inviteUserData, serializeErr := proto.Marshal(&inviteUserReq)
+	if serializeErr != nil {
+		return serializeErr
+	}
+	return client.send(ctx, "invite", inviteUserData)
+}
+
+func (client *Client) JoinGroup(ctx context.Context, groupName string) (*Messages.GroupResp, error) {
+	joinGroupMsg := Messages.JoinGroupReq{
+		GroupName: groupName,
+	}
+	joinGroupData, serializeErr := proto.Marshal(&joinGroupMsg)
+	if serializeErr != nil {
+		return nil, serializeErr
+	}
+
+	groupMsg, reqErr := client.request(ctx, "joinGroup", joinGroupData, "group", "joinGroupErr")
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if groupMsg.typeID != "group" {
+		return nil, serverError("joinGroup", groupMsg)
+	}
+	group := Messages.GroupResp{}
+	proto.Unmarshal(groupMsg.body, &group)
+	client.session.setGroup(groupName)
+	return &group, nil
+}
+
+func (client *Client) Groups(ctx context.Context) ([]string, error) {
+	getGroupsMsg, reqErr := client.request(ctx, "getGroups", nil, "getGroups", "getGroupsErr")
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if getGroupsMsg.typeID != "getGroups" {
+		return nil, serverError("getGroups", getGroupsMsg)
+	}
+	getGroupsResp := Messages.GroupsResp{}
+	parseErr := proto.Unmarshal(getGroupsMsg.body, &getGroupsResp)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return getGroupsResp.GroupNames, nil
+}
+
+
+func (client *Client) Invites(ctx context.Context) ([]*Messages.InvitesResp_Invite, error) {
+	getInvitesMsg, reqErr := client.request(ctx, "getInvites", nil, "getInvites", "getInvitesErr")
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if getInvitesMsg.typeID != "getInvites" {
+		return nil, serverError("getInvites", getInvitesMsg)
+	}
+	getInvitesResp := Messages.InvitesResp{}
+	parseErr := proto.Unmarshal(getInvitesMsg.body, &getInvitesResp)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return getInvitesResp.Invites, nil
+}
+
+func (client *Client) AcceptInvite(ctx context.Context, inviteID string) ([]*Messages.InvitesResp_Invite, error) {
+	acceptInviteReq := Messages.AcceptInviteReq{
+		InviteID: inviteID,
+	}
+	acceptInviteData, serializeErr := proto.Marshal(&acceptInviteReq)
+	if serializeErr != nil {
+		return nil, serializeErr
+	}
+	getInvitesMsg, reqErr := client.request(ctx, "acceptInvite", acceptInviteData, "getInvites", "acceptInviteErr", "getInvitesErr")
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if getInvitesMsg.typeID != "getInvites" {
+		return nil, serverError("acceptInvite", getInvitesMsg)
+	}
+	getInvitesResp := Messages.InvitesResp{}
+	parseErr := proto.Unmarshal(getInvitesMsg.body, &getInvitesResp)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return getInvitesResp.Invites, nil
+}
+
+func (client *Client) DeclineInvite(ctx context.Context, inviteID string) ([]*Messages.InvitesResp_Invite, error) {
+	deleteInviteReq := Messages.DeleteInviteReq{
+		InviteID: inviteID,
+	}
+	deleteInviteData, serializeErr := proto.Marshal(&deleteInviteReq)
+	if serializeErr != nil {
+		return nil, serializeErr
+	}
+	getInvitesMsg, reqErr := client.request(ctx, "deleteInvite", deleteInviteData, "getInvites", "deleteInviteErr", "getInvitesErr")
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if getInvitesMsg.typeID != "getInvites" {
+		return nil, serverError("deleteInvite", getInvitesMsg)
+	}
+	getInvitesResp := Messages.InvitesResp{}
+	parseErr := proto.Unmarshal(getInvitesMsg.body, &getInvitesResp)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return getInvitesResp.Invites, nil
+}
\ No newline at end of file
@@ -0,0 +1,136 @@
+package initchat
+
+import (
+	"../Messages"
+	"./initchattest"
+	"errors"
+	"testing"
+)
+
+//Connects a client to a fresh mock server through proxyURL
+func connectThroughProxy(t *testing.T, newServer func() (*initchattest.Server, error), proxyURL string) (*initchattest.Server, *Client, error) {
+	t.Helper()
+	server, err := newServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	dialer := &Dialer{TLSConfig: server.ClientTLS, Proxy: proxyURL}
+	client, err := Connect(func() (Transport, error) {
+		return dialer.Dial(server.URL)
+	})
+	if err != nil {
+		return server, nil, err
+	}
+	t.Cleanup(client.Close)
+	return server, client, nil
+}
+
+func TestProxies(t *testing.T) {
+	proxies := map[string]func(string, string) (*initchattest.Proxy, error){
+		"connect": initchattest.NewConnectProxy,
+		"socks5": initchattest.NewSOCKS5Proxy,
+	}
+	servers := map[string]func() (*initchattest.Server, error){
+		"tls": initchattest.NewServer,
+		"wss": initchattest.NewWebSocketServer,
+	}
+	for proxyName, newProxy := range proxies {
+		for serverName, newServer := range servers {
+			t.Run(proxyName + "/" + serverName, func(t *testing.T) {
+				proxy, err := newProxy("user", "p@ss")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer proxy.Close()
+				server, client, err := connectThroughProxy(t, newServer, proxy.URL)
+				if err != nil {
+					t.Fatal(err)
+				}
+				server.Expect("login").Respond("auth", &Messages.AuthResp{Token: "token1"})
+				if err := client.Login(testContext(t), "alice", "secret"); err != nil {
+					t.Fatal(err)
+				}
+				verifyServer(t, server)
+				if tunnels := proxy.Tunnels(); len(tunnels) != 1 || tunnels[0] != server.Addr {
+					t.Errorf("proxy tunnels = %v, want one to %s", tunnels, server.Addr)
+				}
+			})
+		}
+	}
+}
+
+func TestProxyRejectsCredentials(t *testing.T) {
+	connectProxy, err := initchattest.NewConnectProxy("user", "right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connectProxy.Close()
+	_, _, err = connectThroughProxy(t, initchattest.NewServer, "http://user:wrong@" + connectProxy.Addr)
+	var proxyErr *ProxyError
+	if !errors.As(err, &proxyErr) || proxyErr.Status != "407 Proxy Authentication Required" {
+		t.Fatalf("Connect() through a proxy with the wrong password = %v, want a 407 ProxyError", err)
+	}
+
+	socksProxy, err := initchattest.NewSOCKS5Proxy("user", "right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socksProxy.Close()
+	if _, _, err := connectThroughProxy(t, initchattest.NewServer, "socks5://user:wrong@" + socksProxy.Addr); err == nil {
+		t.Fatal("Connect() through a SOCKS5 proxy with the wrong password succeeded")
+	}
+	if connectProxy.Refused() != 1 || socksProxy.Refused() != 1 {
+		t.Errorf("proxies refused %d and %d connections, want 1 each", connectProxy.Refused(), socksProxy.Refused())
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy", "REQUEST_METHOD"} {
+		t.Setenv(name, "")
+	}
+	tests := []struct {
+		name string
+		env map[string]string
+		address string
+		want string
+	}{
+		{"unset", nil, "chat.example.com:2750", ""},
+		{"https proxy", map[string]string{"HTTPS_PROXY": "http://proxy.corp:3128"}, "chat.example.com:2750", "http://proxy.corp:3128"},
+		{"default port", map[string]string{"https_proxy": "http://proxy.corp"}, "chat.example.com:2750", "http://proxy.corp:80"},
+		{"no scheme", map[string]string{"HTTPS_PROXY": "proxy.corp:3128"}, "chat.example.com:2750", "http://proxy.corp:3128"},
+		{"all proxy", map[string]string{"ALL_PROXY": "socks5://gate:1080"}, "chat.example.com:2750", "socks5://gate:1080"},
+		{"https proxy first", map[string]string{"HTTPS_PROXY": "http://proxy.corp:3128", "ALL_PROXY": "socks5://gate:1080"}, "chat.example.com:2750", "http://proxy.corp:3128"},
+		{"no proxy", map[string]string{"ALL_PROXY": "socks5://gate:1080", "NO_PROXY": ".example.com"}, "chat.example.com:2750", ""},
+		{"loopback", map[string]string{"HTTPS_PROXY": "http://proxy.corp:3128"}, "127.0.0.1:2750", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			proxyURL, err := ProxyFromEnvironment(test.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if proxyURL != nil {
+				got = proxyURL.String()
+			}
+			if got != test.want {
+				t.Errorf("ProxyFromEnvironment(%q) = %q, want %q", test.address, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	if proxyURL, err := ParseProxyURL("socks5h://u:p@gate"); err != nil || proxyURL.Host != "gate:1080" {
+		t.Errorf("ParseProxyURL() = %v, %v, want port 1080 filled in", proxyURL, err)
+	}
+	for _, rawURL := range []string{"ftp://proxy:21", "http://", "socks4://gate:1080"} {
+		if _, err := ParseProxyURL(rawURL); err == nil {
+			t.Errorf("ParseProxyURL(%q) accepted an unsupported proxy", rawURL)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+/*
+	Keeps the client connected by redialing and restoring the session after a disconnect
+ */
+
+package initchat
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type ConnState int
+
+const (
+	Connected ConnState = iota
+	Disconnected
+	Reconnecting
+)
+
+func (state ConnState) String() string {
+	switch state {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Reconnecting:
+		return "reconnecting"
+	}
+	return "unknown"
+}
+
+var ReconnectBaseDelay = 500 * time.Millisecond
+var ReconnectMaxDelay = 30 * time.Second
+
+//Time allowed for re-authenticating and rejoining once a new connection is up
+var ResumeTimeout = 10 * time.Second
+
+//Everything needed to put the user back where they were after reconnecting
+type Session struct {
+	mutex sync.Mutex
+	token string
+	expireTime uint64
+	username string
+	password string
+	//Logged in with the client certificate, which can log in again in place of a password
+	certificate bool
+	groupName string
+}
+
+func (session *Session) setAuth(username string, password string, token string, expireTime uint64) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.username = username
+	session.password = password
+	session.certificate = false
+	session.token = token
+	session.expireTime = expireTime
+}
+
+func (session *Session) setCertificateAuth(username string, token string, expireTime uint64) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.username = username
+	session.password = ""
+	session.certificate = true
+	session.token = token
+	session.expireTime = expireTime
+}
+
+func (session *Session) usesCertificate() bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.certificate
+}
+
+func (session *Session) setGroup(groupName string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.groupName = groupName
+}
+
+func (session *Session) clear() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.username = ""
+	session.password = ""
+	session.certificate = false
+	session.token = ""
+	session.expireTime = 0
+	session.groupName = ""
+}
+
+func (session *Session) snapshot() (username string, password string, token string, groupName string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.username, session.password, session.token, session.groupName
+}
+
+//Credentials from the latest AuthResp, enough to resume without the password
+type SessionToken struct {
+	Username string `json:"username"`
+	Token string `json:"token"`
+	ExpireTime uint64 `json:"expireTime"`
+}
+
+//Unix time the token stops working, zero time if the server gave no expiry
+func (token SessionToken) Expires() time.Time {
+	if token.ExpireTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(token.ExpireTime), 0)
+}
+
+func (token SessionToken) Expired() bool {
+	return token.ExpireTime != 0 && time.Now().After(token.Expires())
+}
+
+func (client *Client) SessionToken() SessionToken {
+	client.session.mutex.Lock()
+	defer client.session.mutex.Unlock()
+	return SessionToken{
+		Username: client.session.username,
+		Token: client.session.token,
+		ExpireTime: client.session.expireTime,
+	}
+}
+
+//Notifies the display without ever blocking the network goroutines
+func (client *Client) setState(state ConnState) {
+	select {
+	case client.stateChannel <- state:
+	default:
+	}
+}
+
+//Exponential backoff capped at ReconnectMaxDelay with up to half of the delay randomized
+func backoffDelay(attempt int) time.Duration {
+	delay := ReconnectMaxDelay
+	if attempt < 16 {
+		if scaled := ReconnectBaseDelay << uint(attempt); scaled < delay {
+			delay = scaled
+		}
+	}
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half + 1))
+}
+
+//Forgets the stored credentials and group so nothing is restored after a reconnect
+func (client *Client) SignOut() {
+	client.session.clear()
+}
+
+func (client *Client) reconnect() {
+	client.setConnection(nil)
+	client.setState(Disconnected)
+	for attempt := 0; ; attempt++ {
+		time.Sleep(backoffDelay(attempt))
+		if client.isClosed() {
+			return
+		}
+		client.setState(Reconnecting)
+		conn, err := client.dial()
+		if err != nil {
+			log.Println("Reconnect failed: ", err)
+			continue
+		}
+		client.setConnection(conn)
+		go client.runRead(conn)
+		if resumeErr := client.resumeSession(); resumeErr != nil {
+			log.Println("Could not restore session: ", resumeErr)
+		}
+		client.setState(Connected)
+		return
+	}
+}
+
+//Re-authenticates with the stored token, falling back to the password or certificate, then rejoins the open group
+func (client *Client) resumeSession() error {
+	username, password, token, groupName := client.session.snapshot()
+	if username == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ResumeTimeout)
+	defer cancel()
+	authErr := errNoToken
+	if token != "" {
+		authErr = client.Resume(ctx, username, token)
+	}
+	if authErr != nil {
+		var loginErr error
+		switch {
+		case client.session.usesCertificate():
+			loginErr = client.LoginWithCertificate(ctx, username)
+		case password != "":
+			loginErr = client.Login(ctx, username, password)
+		default:
+			return authErr
+		}
+		if loginErr != nil {
+			return loginErr
+		}
+	}
+	if groupName != "" {
+		if _, joinErr := client.JoinGroup(ctx, groupName); joinErr != nil {
+			return joinErr
+		}
+	}
+	return nil
+}
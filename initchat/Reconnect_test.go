@@ -0,0 +1,80 @@
+package initchat
+
+import (
+	"../Messages"
+	"testing"
+	"time"
+)
+
+func TestReconnectResumesSession(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("login").Respond("auth", &Messages.AuthResp{Token: "token1"})
+	server.Expect("joinGroup").Respond("group", &Messages.GroupResp{})
+	if err := client.Login(testContext(t), "alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.JoinGroup(testContext(t), "team"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Expect("resume").
+		WithBody(&Messages.ResumeReq{Token: "token1"}).
+		Respond("auth", &Messages.AuthResp{Token: "token2"})
+	server.Expect("joinGroup").
+		WithBody(&Messages.JoinGroupReq{GroupName: "team"}).
+		Respond("group", &Messages.GroupResp{})
+	server.DropConnections()
+
+	waitForState(t, client, Connected)
+	verifyServer(t, server)
+	if got := client.SessionToken(); got.Token != "token2" {
+		t.Errorf("token after reconnecting = %q, want token2", got.Token)
+	}
+}
+
+func TestReconnectFallsBackToPassword(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("login").Respond("auth", &Messages.AuthResp{Token: "expired"})
+	if err := client.Login(testContext(t), "bob", "pw"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Expect("resume").RespondError("authErr", CodeInvalidCredentials, "token expired")
+	server.Expect("login").
+		WithBody(&Messages.LoginReq{Username: "bob", Password: "pw"}).
+		Respond("auth", &Messages.AuthResp{Token: "fresh"})
+	server.DropConnections()
+
+	waitForState(t, client, Connected)
+	verifyServer(t, server)
+	if got := client.SessionToken(); got.Token != "fresh" {
+		t.Errorf("token after reconnecting = %q, want fresh", got.Token)
+	}
+}
+
+func TestSignOutRestoresNothing(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Expect("login").Respond("auth", &Messages.AuthResp{Token: "token1"})
+	if err := client.Login(testContext(t), "carol", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	client.SignOut()
+	server.DropConnections()
+
+	waitForState(t, client, Connected)
+	//Anything the client sent after reconnecting would be reported as unexpected
+	time.Sleep(50 * time.Millisecond)
+	verifyServer(t, server)
+}
+
+func TestSendWhileDisconnected(t *testing.T) {
+	server, client := newTestClient(t)
+	server.Close()
+	waitForState(t, client, Disconnected)
+	if err := client.SendText(testContext(t), "hello"); err != ErrDisconnected {
+		t.Fatalf("SendText() while disconnected = %v, want ErrDisconnected", err)
+	}
+	if _, err := client.Groups(testContext(t)); err != ErrDisconnected {
+		t.Fatalf("Groups() while disconnected = %v, want ErrDisconnected", err)
+	}
+}
@@ -0,0 +1,273 @@
+/*
+	Chunked file upload and download that can resume where an interrupted transfer stopped
+ */
+
+package initchat
+
+import (
+	"../Messages"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var TransferChunkSize = 64 * 1024
+
+//Time the server has to answer a single chunk, the whole transfer can take longer
+var ChunkTimeout = 30 * time.Second
+
+//A downloaded file that doesn't match what the server said it sent, matched by errors.Is(err, ErrCorruptFile)
+type IntegrityError struct {
+	FileID string
+	Reason string
+}
+
+func (err *IntegrityError) Error() string {
+	return "file " + err.FileID + " is corrupted: " + err.Reason
+}
+
+func (err *IntegrityError) Unwrap() error {
+	return ErrCorruptFile
+}
+
+//SHA-256 of everything from the start of the file, leaving it positioned at the start again
+func hashFile(file *os.File) ([]byte, error) {
+	if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, seekErr
+	}
+	hash := sha256.New()
+	if _, copyErr := io.Copy(hash, file); copyErr != nil {
+		return nil, copyErr
+	}
+	if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, seekErr
+	}
+	return hash.Sum(nil), nil
+}
+
+//Called after every chunk with the bytes transferred so far
+type ProgressFunc func(done uint64, total uint64)
+
+//Sends one transfer frame and decodes the success response into resp
+func (client *Client) transferRequest(ctx context.Context, typeID string, req proto.Message, okType string, errType string, resp proto.Message) error {
+	reqData, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	chunkCtx, cancel := context.WithTimeout(ctx, ChunkTimeout)
+	defer cancel()
+	respMsg, reqErr := client.request(chunkCtx, typeID, reqData, okType, errType)
+	if reqErr != nil {
+		return reqErr
+	}
+	if respMsg.typeID != okType {
+		return serverError(typeID, respMsg)
+	}
+	return proto.Unmarshal(respMsg.body, resp)
+}
+
+//Same file, size and modification time always map to the same ID so the server can resume it
+func uploadTransferID(filePath string, info os.FileInfo) string {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		absPath = filePath
+	}
+	sum := sha256.Sum256([]byte(absPath + "|" + strconv.FormatInt(info.Size(), 10) + "|" +
+		strconv.FormatInt(info.ModTime().UnixNano(), 10)))
+	return hex.EncodeToString(sum[:16])
+}
+
+//Streams the file to the current group and returns the file ID the server assigned
+func (client *Client) Upload(ctx context.Context, filePath string, progress ProgressFunc) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", errors.New("Could not load file: " + err.Error())
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := uint64(info.Size())
+	transferID := uploadTransferID(filePath, info)
+	digest, hashErr := hashFile(file)
+	if hashErr != nil {
+		return "", hashErr
+	}
+
+	status := Messages.TransferStatus{}
+	startReq := Messages.UploadStartReq{
+		TransferID: transferID,
+		Name: filepath.Base(filePath),
+		Size: size,
+		Sha256: digest,
+	}
+	if startErr := client.transferRequest(ctx, "uploadStart", &startReq, "transferStatus", "uploadErr", &status); startErr != nil {
+		return "", startErr
+	}
+	//The server reports how much of an earlier attempt it already has
+	offset := status.Offset
+	if offset > size {
+		offset = 0
+	}
+	if _, seekErr := file.Seek(int64(offset), io.SeekStart); seekErr != nil {
+		return "", seekErr
+	}
+	if progress != nil {
+		progress(offset, size)
+	}
+
+	buffer := make([]byte, TransferChunkSize)
+	for offset < size {
+		n, readErr := io.ReadFull(file, buffer)
+		if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			return "", readErr
+		}
+		chunk := Messages.FileChunk{
+			TransferID: transferID,
+			Offset: offset,
+			Data: buffer[:n],
+		}
+		if chunkErr := client.transferRequest(ctx, "uploadChunk", &chunk, "transferStatus", "uploadErr", &status); chunkErr != nil {
+			return "", chunkErr
+		}
+		offset += uint64(n)
+		if progress != nil {
+			progress(offset, size)
+		}
+	}
+
+	endReq := Messages.UploadEndReq{
+		TransferID: transferID,
+	}
+	if endErr := client.transferRequest(ctx, "uploadEnd", &endReq, "transferStatus", "uploadErr", &status); endErr != nil {
+		return "", endErr
+	}
+	return status.FileID, nil
+}
+
+//Whether name is a file name of its own, which can't point outside the directory it is joined to
+func isPlainFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && name == filepath.Base(name) && !strings.ContainsAny(name, "/\\")
+}
+
+//Downloads into a partial file in dir, continuing one left by an earlier attempt,
+//and renames it into place once complete. Returns the path of the finished file.
+func (client *Client) Download(ctx context.Context, fileID string, dir string, progress ProgressFunc) (string, error) {
+	if !isPlainFileName(fileID) {
+		return "", errors.New("Invalid file ID")
+	}
+	info := Messages.FileInfo{}
+	startReq := Messages.DownloadReq{
+		FileID: fileID,
+	}
+	if startErr := client.transferRequest(ctx, "downloadStart", &startReq, "fileInfo", "downloadErr", &info); startErr != nil {
+		return "", startErr
+	}
+
+	if dirErr := os.MkdirAll(dir, 0755); dirErr != nil {
+		return "", dirErr
+	}
+	partPath := filepath.Join(dir, "." + fileID + ".part")
+	part, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer part.Close()
+	partInfo, err := part.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := uint64(partInfo.Size())
+	if offset > info.Size {
+		offset = 0
+		if truncErr := part.Truncate(0); truncErr != nil {
+			return "", truncErr
+		}
+	}
+	if _, seekErr := part.Seek(int64(offset), io.SeekStart); seekErr != nil {
+		return "", seekErr
+	}
+	if progress != nil {
+		progress(offset, info.Size)
+	}
+
+	for offset < info.Size {
+		chunkReq := Messages.ChunkReq{
+			FileID: fileID,
+			Offset: offset,
+			Length: uint32(TransferChunkSize),
+		}
+		chunk := Messages.FileChunk{}
+		if chunkErr := client.transferRequest(ctx, "downloadChunk", &chunkReq, "fileChunk", "downloadErr", &chunk); chunkErr != nil {
+			return "", chunkErr
+		}
+		if chunk.Offset != offset || len(chunk.Data) == 0 {
+			return "", errors.New("Server sent chunk at offset " + strconv.FormatUint(chunk.Offset, 10) +
+				", expected " + strconv.FormatUint(offset, 10))
+		}
+		if _, writeErr := part.Write(chunk.Data); writeErr != nil {
+			return "", writeErr
+		}
+		offset += uint64(len(chunk.Data))
+		if progress != nil {
+			progress(offset, info.Size)
+		}
+	}
+
+	if syncErr := part.Sync(); syncErr != nil {
+		return "", syncErr
+	}
+	if verifyErr := verifyDownload(part, &info); verifyErr != nil {
+		//A bad partial file would otherwise be resumed forever
+		part.Close()
+		os.Remove(partPath)
+		return "", verifyErr
+	}
+	if closeErr := part.Close(); closeErr != nil {
+		return "", closeErr
+	}
+	filePath := filepath.Join(dir, fileID)
+	if renameErr := os.Rename(partPath, filePath); renameErr != nil {
+		return "", renameErr
+	}
+	return filePath, nil
+}
+
+//Checks the finished partial file against the size and digest from FileInfo
+func verifyDownload(part *os.File, info *Messages.FileInfo) error {
+	partInfo, err := part.Stat()
+	if err != nil {
+		return err
+	}
+	if uint64(partInfo.Size()) != info.Size {
+		return &IntegrityError{
+			FileID: info.FileID,
+			Reason: "got " + strconv.FormatInt(partInfo.Size(), 10) + " of " + strconv.FormatUint(info.Size, 10) + " bytes",
+		}
+	}
+	//Servers that predate digests send none, so only the size can be checked
+	if len(info.Sha256) == 0 {
+		return nil
+	}
+	digest, hashErr := hashFile(part)
+	if hashErr != nil {
+		return hashErr
+	}
+	if !bytes.Equal(digest, info.Sha256) {
+		return &IntegrityError{
+			FileID: info.FileID,
+			Reason: "SHA-256 " + hex.EncodeToString(digest) + " does not match expected " + hex.EncodeToString(info.Sha256),
+		}
+	}
+	return nil
+}
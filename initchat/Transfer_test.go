@@ -0,0 +1,338 @@
+package initchat
+
+import (
+	"../Messages"
+	"./initchattest"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//Writes size random bytes to a file in a test directory
+func writeTestFile(t *testing.T, name string, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func waitForState(t *testing.T, client *Client, want ConnState) {
+	t.Helper()
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case state := <-client.States():
+			if state == want {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("client never became %v", want)
+		}
+	}
+}
+
+func TestUploadDownload(t *testing.T) {
+	server, client := newTestClient(t)
+	server.ServeFiles()
+	path, data := writeTestFile(t, "report.bin", 3 * TransferChunkSize + 100)
+
+	var lastDone, lastTotal uint64
+	fileID, err := client.Upload(testContext(t), path, func(done uint64, total uint64) {
+		lastDone, lastTotal = done, total
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastDone != uint64(len(data)) || lastTotal != uint64(len(data)) {
+		t.Errorf("last progress = %d / %d, want %d", lastDone, lastTotal, len(data))
+	}
+	stored, ok := server.File(fileID)
+	if !ok || !bytes.Equal(stored, data) {
+		t.Fatalf("server stored %d bytes for %q, want the uploaded %d", len(stored), fileID, len(data))
+	}
+
+	dir := t.TempDir()
+	downloaded, err := client.Download(testContext(t), fileID, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if downloaded != filepath.Join(dir, fileID) {
+		t.Errorf("Download() path = %q", downloaded)
+	}
+	got, err := ioutil.ReadFile(downloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded file differs from the uploaded one")
+	}
+	verifyServer(t, server)
+}
+
+func TestUploadEmptyFile(t *testing.T) {
+	server, client := newTestClient(t)
+	server.ServeFiles()
+	path, _ := writeTestFile(t, "empty", 0)
+
+	fileID, err := client.Upload(testContext(t), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, ok := server.File(fileID); !ok || len(stored) != 0 {
+		t.Errorf("server stored %v, %v for an empty file", stored, ok)
+	}
+}
+
+func TestUploadResumesAfterDisconnect(t *testing.T) {
+	server, client := newTestClient(t)
+	server.ServeFiles()
+	storeChunk := server.Handler("uploadChunk")
+	chunks := 0
+	server.Handle("uploadChunk", func(conn *initchattest.Conn, frame initchattest.Frame) {
+		chunks++
+		if chunks == 3 {
+			conn.Close()
+			return
+		}
+		storeChunk(conn, frame)
+	})
+	path, data := writeTestFile(t, "big.bin", 4 * TransferChunkSize)
+
+	if _, err := client.Upload(testContext(t), path, nil); err != ErrDisconnected {
+		t.Fatalf("Upload() during a disconnect = %v, want ErrDisconnected", err)
+	}
+	waitForState(t, client, Connected)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transferID := uploadTransferID(path, info)
+	if offset, ok := server.UploadOffset(transferID); !ok || offset != uint64(2 * TransferChunkSize) {
+		t.Fatalf("server holds %d bytes of the interrupted upload, want %d", offset, 2 * TransferChunkSize)
+	}
+	var firstDone uint64
+	progressCalled := false
+	fileID, err := client.Upload(testContext(t), path, func(done uint64, total uint64) {
+		if !progressCalled {
+			firstDone, progressCalled = done, true
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstDone != uint64(2 * TransferChunkSize) {
+		t.Errorf("resumed upload started at %d, want %d", firstDone, 2 * TransferChunkSize)
+	}
+	if stored, _ := server.File(fileID); !bytes.Equal(stored, data) {
+		t.Error("resumed upload differs from the file")
+	}
+}
+
+func TestUploadCorrupted(t *testing.T) {
+	server, client := newTestClient(t)
+	server.ServeFiles()
+	server.Expect("uploadStart").Respond("transferStatus", &Messages.TransferStatus{})
+	server.Expect("uploadChunk").Respond("transferStatus", &Messages.TransferStatus{})
+	server.Expect("uploadEnd").RespondError("uploadErr", CodeCorruptFile, "")
+	path, _ := writeTestFile(t, "small.txt", 10)
+
+	if _, err := client.Upload(testContext(t), path, nil); !errors.Is(err, ErrCorruptFile) {
+		t.Fatalf("Upload() error = %v, want ErrCorruptFile", err)
+	}
+	verifyServer(t, server)
+}
+
+func TestUploadMissingFile(t *testing.T) {
+	_, client := newTestClient(t)
+	if _, err := client.Upload(testContext(t), filepath.Join(t.TempDir(), "missing"), nil); err == nil {
+		t.Fatal("Upload() of a missing file succeeded")
+	}
+}
+
+func TestDownloadNotFound(t *testing.T) {
+	server, client := newTestClient(t)
+	server.ServeFiles()
+
+	if _, err := client.Download(testContext(t), "nope", t.TempDir(), nil); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("Download() error = %v, want ErrFileNotFound", err)
+	}
+}
+
+//File IDs come from the server and other members, none may name a path outside the download directory
+func TestDownloadInvalidFileID(t *testing.T) {
+	server, client := newTestClient(t)
+	server.ServeFiles()
+	dir := filepath.Join(t.TempDir(), "downloads")
+	for _, fileID := range []string{"", ".", "..", "../escape", "sub/file", "sub\\file", "/etc/passwd", "/"} {
+		if path, err := client.Download(testContext(t), fileID, dir, nil); err == nil {
+			t.Errorf("Download(%q) saved to %s", fileID, path)
+		}
+	}
+	if received := server.Received(); len(received) != 0 {
+		t.Errorf("invalid file IDs were requested %d times", len(received))
+	}
+}
+
+func TestDownloadDigestMismatch(t *testing.T) {
+	server, client := newTestClient(t)
+	server.ServeFiles()
+	data := []byte("the real contents")
+	server.AddFile("f1", "notes.txt", data)
+	server.Expect("downloadStart").Respond("fileInfo", &Messages.FileInfo{
+		FileID: "f1",
+		Size: uint64(len(data)),
+		Sha256: make([]byte, 32),
+	})
+
+	dir := t.TempDir()
+	_, err := client.Download(testContext(t), "f1", dir, nil)
+	var integrityErr *IntegrityError
+	if !errors.Is(err, ErrCorruptFile) || !errors.As(err, &integrityErr) {
+		t.Fatalf("Download() error = %v, want an IntegrityError", err)
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("corrupted download left %d files behind", len(entries))
+	}
+	verifyServer(t, server)
+}
+
+func TestDownloadResumesPartialFile(t *testing.T) {
+	server, client := newTestClient(t)
+	server.ServeFiles()
+	data := make([]byte, 2 * TransferChunkSize + 7)
+	rand.Read(data)
+	server.AddFile("f2", "photo.jpg", data)
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, ".f2.part"), data[:TransferChunkSize], 0644); err != nil {
+		t.Fatal(err)
+	}
+	chunkRequests := 0
+	serveChunk := server.Handler("downloadChunk")
+	server.Handle("downloadChunk", func(conn *initchattest.Conn, frame initchattest.Frame) {
+		chunkRequests++
+		serveChunk(conn, frame)
+	})
+
+	path, err := client.Download(testContext(t), "f2", dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunkRequests != 2 {
+		t.Errorf("resumed download requested %d chunks, want 2", chunkRequests)
+	}
+	if got, _ := ioutil.ReadFile(path); !bytes.Equal(got, data) {
+		t.Error("resumed download differs from the served file")
+	}
+}
+
+func TestEncryptedUploadDownload(t *testing.T) {
+	useCacheDir(t)
+	server, client := newTestClient(t)
+	server.ServeFiles()
+	path, data := writeTestFile(t, "secret.pdf", TransferChunkSize + 5)
+
+	fileID, key, err := client.UploadEncrypted(testContext(t), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := server.File(fileID)
+	if bytes.Contains(stored, data[:64]) {
+		t.Fatal("server received the file in the clear")
+	}
+	fileKey, ok := ParseFileKeyText(FileKeyText(FileKey{FileID: fileID, Name: "secret.pdf", Size: uint64(len(data)), Key: key}))
+	if !ok {
+		t.Fatal("file key text did not parse")
+	}
+
+	dir := t.TempDir()
+	downloaded, err := client.DownloadEncrypted(testContext(t), *fileKey, dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if downloaded != filepath.Join(dir, "secret.pdf") {
+		t.Errorf("DownloadEncrypted() path = %q", downloaded)
+	}
+	if got, _ := ioutil.ReadFile(downloaded); !bytes.Equal(got, data) {
+		t.Error("decrypted file differs from the original")
+	}
+
+	//The server's digest matches what it stores, only the authentication tags catch the change
+	tampered := append([]byte(nil), stored...)
+	tampered[len(tampered) / 2] ^= 1
+	server.AddFile(fileID, "", tampered)
+	if _, err := client.DownloadEncrypted(testContext(t), *fileKey, t.TempDir(), nil); !errors.Is(err, ErrCorruptFile) {
+		t.Fatalf("DownloadEncrypted() of a tampered file = %v, want ErrCorruptFile", err)
+	}
+}
+
+//The sender picks the name, so it can neither leave the directory nor replace a file already there
+func TestEncryptedDownloadName(t *testing.T) {
+	useCacheDir(t)
+	server, client := newTestClient(t)
+	server.ServeFiles()
+	path, data := writeTestFile(t, "secret.pdf", 100)
+	fileID, key, err := client.UploadEncrypted(testContext(t), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(fileID))
+	hashed := hex.EncodeToString(sum[:8])
+
+	tests := []struct {
+		name string
+		existing []string
+		want string
+	}{
+		{"secret.pdf", nil, "secret.pdf"},
+		{"secret.pdf", []string{"secret.pdf"}, "secret (2).pdf"},
+		{"secret.pdf", []string{"secret.pdf", "secret (2).pdf"}, "secret (3).pdf"},
+		{"../../secret.pdf", nil, "secret.pdf"},
+		{"..", nil, hashed},
+		{".", nil, hashed},
+		{"", nil, hashed},
+		{"/", nil, hashed},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		for _, existing := range test.existing {
+			if writeErr := ioutil.WriteFile(filepath.Join(dir, existing), []byte("keep"), 0644); writeErr != nil {
+				t.Fatal(writeErr)
+			}
+		}
+		downloaded, err := client.DownloadEncrypted(testContext(t), FileKey{FileID: fileID, Name: test.name, Key: key}, dir, nil)
+		if err != nil {
+			t.Errorf("name %q: %v", test.name, err)
+			continue
+		}
+		if downloaded != filepath.Join(dir, test.want) {
+			t.Errorf("name %q downloaded to %q, want %q", test.name, downloaded, test.want)
+		}
+		if got, _ := ioutil.ReadFile(downloaded); !bytes.Equal(got, data) {
+			t.Errorf("name %q: decrypted file differs from the original", test.name)
+		}
+		for _, existing := range test.existing {
+			if got, _ := ioutil.ReadFile(filepath.Join(dir, existing)); string(got) != "keep" {
+				t.Errorf("name %q replaced %s", test.name, existing)
+			}
+		}
+		entries, _ := ioutil.ReadDir(dir)
+		if len(entries) != len(test.existing) + 1 {
+			t.Errorf("name %q left %d files, want %d", test.name, len(entries), len(test.existing) + 1)
+		}
+	}
+}
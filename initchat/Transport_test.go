@@ -0,0 +1,90 @@
+package initchat
+
+import (
+	"../Messages"
+	"./initchattest"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseServerURL(t *testing.T) {
+	tests := []struct {
+		address string
+		scheme string
+		host string
+		path string
+	}{
+		{"127.0.0.1:2750", SchemeTLS, "127.0.0.1:2750", ""},
+		{"tls://chat.example.com:2750", SchemeTLS, "chat.example.com:2750", ""},
+		{"tcp://localhost:2750", SchemeTCP, "localhost:2750", ""},
+		{"wss://chat.example.com/initchat", SchemeWebSocket, "chat.example.com", "/initchat"},
+		{"wss://chat.example.com:8443", SchemeWebSocket, "chat.example.com:8443", "/"},
+	}
+	for _, test := range tests {
+		serverURL, err := ParseServerURL(test.address)
+		if err != nil {
+			t.Errorf("ParseServerURL(%q) error = %v", test.address, err)
+			continue
+		}
+		if serverURL.Scheme != test.scheme || serverURL.Host != test.host || serverURL.Path != test.path {
+			t.Errorf("ParseServerURL(%q) = %s %s %s", test.address, serverURL.Scheme, serverURL.Host, serverURL.Path)
+		}
+	}
+
+	for _, address := range []string{"localhost", "tls://localhost", "tcp://localhost:2750/chat", "wss:///initchat", "ws://localhost:80/", "http://localhost"} {
+		if _, err := ParseServerURL(address); err == nil {
+			t.Errorf("ParseServerURL(%q) accepted an invalid address", address)
+		}
+	}
+}
+
+func TestTransports(t *testing.T) {
+	servers := map[string]func() (*initchattest.Server, error){
+		"tls": initchattest.NewServer,
+		"wss": initchattest.NewWebSocketServer,
+		"tcp": initchattest.NewTCPServer,
+	}
+	for name, newServer := range servers {
+		t.Run(name, func(t *testing.T) {
+			server, client := connectTestServer(t, newServer)
+			server.ServeFiles()
+			stream := client.TextMessages()
+			defer stream.Close()
+
+			server.Expect("login").Respond("auth", &Messages.AuthResp{Token: "token1"})
+			server.Expect("textMsg").WithoutRequestID().Push("message", &Messages.TextMessage{Username: "alice", Message: "ping"})
+			if err := client.Login(testContext(t), "alice", "secret"); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.SendText(testContext(t), "ping"); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case textMsg := <-stream.Messages():
+				if textMsg.Message != "ping" {
+					t.Errorf("streamed message = %v", textMsg)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("pushed message never reached the stream")
+			}
+
+			//Frames larger than any single read of the transport
+			path, data := writeTestFile(t, "big.bin", 2 * TransferChunkSize + 3)
+			fileID, err := client.Upload(testContext(t), path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored, _ := server.File(fileID); !bytes.Equal(stored, data) {
+				t.Error("server stored a different file")
+			}
+
+			server.Expect("resume").
+				WithBody(&Messages.ResumeReq{Token: "token1"}).
+				Respond("auth", &Messages.AuthResp{Token: "token2"})
+			server.DropConnections()
+			waitForState(t, client, Connected)
+			verifyServer(t, server)
+		})
+	}
+}
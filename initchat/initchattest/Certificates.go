@@ -0,0 +1,94 @@
+/*
+	Client certificates for mutual TLS: the server asks for one in every TLS handshake
+	and verifies it against its own CA, which can issue certificates for tests
+ */
+
+package initchattest
+
+import (
+	"../../Messages"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+)
+
+//Same code as initchat.CodeCertificateRejected, which can't be imported from here
+const codeCertificateRejected int32 = 8
+
+//Requests a client certificate without requiring one, so password logins keep working
+func serverTLS(cert tls.Certificate, pool *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs: pool,
+	}
+}
+
+//Issues a client certificate for commonName signed by the server's CA, valid until notAfter,
+//returned as PEM certificate and key
+func (server *Server) IssueClientCert(commonName string, notAfter time.Time) ([]byte, []byte, error) {
+	if server.ca.Leaf == nil {
+		return nil, nil, errors.New("server has no TLS to issue client certificates for")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{CommonName: commonName},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: notAfter,
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, server.ca.Leaf, &key.PublicKey, server.ca.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+//Verified certificates the client presented, empty if it sent none
+func (conn *Conn) PeerCertificates() []*x509.Certificate {
+	var tlsConn *tls.Conn
+	switch transport := conn.conn.(type) {
+	case *tls.Conn:
+		tlsConn = transport
+	case *wsStream:
+		tlsConn, _ = transport.conn.UnderlyingConn().(*tls.Conn)
+	}
+	if tlsConn == nil {
+		return nil
+	}
+	return tlsConn.ConnectionState().PeerCertificates
+}
+
+//Answers certLogin like a server that maps certificates to users by their common name
+func (server *Server) ServeCertLogin(token string) {
+	server.Handle("certLogin", func(conn *Conn, frame Frame) {
+		certs := conn.PeerCertificates()
+		if len(certs) == 0 {
+			conn.Send("authErr", server.responseID(frame), &Messages.Error{Code: codeCertificateRejected, Message: "no client certificate"})
+			return
+		}
+		conn.Send("auth", server.responseID(frame), &Messages.AuthResp{Token: token, Username: certs[0].Subject.CommonName})
+	})
+}
@@ -0,0 +1,189 @@
+/*
+	In-memory file storage answering the chunked upload and download requests
+ */
+
+package initchattest
+
+import (
+	"../../Messages"
+	"bytes"
+	"crypto/sha256"
+	"github.com/golang/protobuf/proto"
+	"strconv"
+)
+
+//Same codes as initchat.CodeFileNotFound and initchat.CodeCorruptFile, which can't be imported from here
+const (
+	codeFileNotFound int32 = 5
+	codeCorruptFile int32 = 7
+)
+
+type storedFile struct {
+	name string
+	data []byte
+}
+
+//An upload in progress, kept so a restarted upload resumes at its offset
+type upload struct {
+	name string
+	size uint64
+	sha256 []byte
+	data []byte
+}
+
+//Answers uploads and downloads from memory so transfers don't need every chunk scripted.
+//Expectations for the same frame types still take precedence.
+func (server *Server) ServeFiles() {
+	server.Handle("uploadStart", server.uploadStart)
+	server.Handle("uploadChunk", server.uploadChunk)
+	server.Handle("uploadEnd", server.uploadEnd)
+	server.Handle("downloadStart", server.downloadStart)
+	server.Handle("downloadChunk", server.downloadChunk)
+}
+
+//Stores a file that can be downloaded as fileID
+func (server *Server) AddFile(fileID string, name string, data []byte) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.files[fileID] = &storedFile{name: name, data: data}
+}
+
+//Contents of an uploaded or added file
+func (server *Server) File(fileID string) ([]byte, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	file, ok := server.files[fileID]
+	if !ok {
+		return nil, false
+	}
+	return file.data, true
+}
+
+//Bytes received so far of the upload with transferID, for checking where a resumed upload started
+func (server *Server) UploadOffset(transferID string) (uint64, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	pending, ok := server.uploads[transferID]
+	if !ok {
+		return 0, false
+	}
+	return uint64(len(pending.data)), true
+}
+
+func (server *Server) uploadStart(conn *Conn, frame Frame) {
+	req := Messages.UploadStartReq{}
+	if err := proto.Unmarshal(frame.Body, &req); err != nil {
+		conn.Send("uploadErr", server.responseID(frame), &Messages.Error{Message: err.Error()})
+		return
+	}
+	server.mutex.Lock()
+	pending, ok := server.uploads[req.TransferID]
+	if !ok || pending.size != req.Size || !bytes.Equal(pending.sha256, req.Sha256) {
+		pending = &upload{name: req.Name, size: req.Size, sha256: req.Sha256}
+		server.uploads[req.TransferID] = pending
+	}
+	offset := uint64(len(pending.data))
+	server.mutex.Unlock()
+	conn.Send("transferStatus", server.responseID(frame), &Messages.TransferStatus{TransferID: req.TransferID, Offset: offset})
+}
+
+func (server *Server) uploadChunk(conn *Conn, frame Frame) {
+	chunk := Messages.FileChunk{}
+	if err := proto.Unmarshal(frame.Body, &chunk); err != nil {
+		conn.Send("uploadErr", server.responseID(frame), &Messages.Error{Message: err.Error()})
+		return
+	}
+	server.mutex.Lock()
+	pending, ok := server.uploads[chunk.TransferID]
+	var errMsg *Messages.Error
+	if !ok {
+		errMsg = &Messages.Error{Code: codeFileNotFound, Message: "unknown transfer " + chunk.TransferID}
+	} else if chunk.Offset != uint64(len(pending.data)) {
+		errMsg = &Messages.Error{Message: "chunk at offset " + strconv.FormatUint(chunk.Offset, 10) +
+			", expected " + strconv.Itoa(len(pending.data))}
+	} else {
+		pending.data = append(pending.data, chunk.Data...)
+	}
+	var offset uint64
+	if ok {
+		offset = uint64(len(pending.data))
+	}
+	server.mutex.Unlock()
+	if errMsg != nil {
+		conn.Send("uploadErr", server.responseID(frame), errMsg)
+		return
+	}
+	conn.Send("transferStatus", server.responseID(frame), &Messages.TransferStatus{TransferID: chunk.TransferID, Offset: offset})
+}
+
+func (server *Server) uploadEnd(conn *Conn, frame Frame) {
+	req := Messages.UploadEndReq{}
+	if err := proto.Unmarshal(frame.Body, &req); err != nil {
+		conn.Send("uploadErr", server.responseID(frame), &Messages.Error{Message: err.Error()})
+		return
+	}
+	server.mutex.Lock()
+	pending, ok := server.uploads[req.TransferID]
+	var errMsg *Messages.Error
+	fileID := ""
+	if !ok {
+		errMsg = &Messages.Error{Code: codeFileNotFound, Message: "unknown transfer " + req.TransferID}
+	} else {
+		digest := sha256.Sum256(pending.data)
+		if uint64(len(pending.data)) != pending.size || (len(pending.sha256) > 0 && !bytes.Equal(digest[:], pending.sha256)) {
+			errMsg = &Messages.Error{Code: codeCorruptFile}
+		} else {
+			server.nextFileID++
+			fileID = "file" + strconv.Itoa(server.nextFileID)
+			server.files[fileID] = &storedFile{name: pending.name, data: pending.data}
+		}
+		delete(server.uploads, req.TransferID)
+	}
+	server.mutex.Unlock()
+	if errMsg != nil {
+		conn.Send("uploadErr", server.responseID(frame), errMsg)
+		return
+	}
+	conn.Send("transferStatus", server.responseID(frame), &Messages.TransferStatus{TransferID: req.TransferID, FileID: fileID})
+}
+
+func (server *Server) downloadStart(conn *Conn, frame Frame) {
+	req := Messages.DownloadReq{}
+	if err := proto.Unmarshal(frame.Body, &req); err != nil {
+		conn.Send("downloadErr", server.responseID(frame), &Messages.Error{Message: err.Error()})
+		return
+	}
+	data, ok := server.File(req.FileID)
+	if !ok {
+		conn.Send("downloadErr", server.responseID(frame), &Messages.Error{Code: codeFileNotFound})
+		return
+	}
+	server.mutex.Lock()
+	name := server.files[req.FileID].name
+	server.mutex.Unlock()
+	digest := sha256.Sum256(data)
+	conn.Send("fileInfo", server.responseID(frame), &Messages.FileInfo{
+		FileID: req.FileID,
+		Name: name,
+		Size: uint64(len(data)),
+		Sha256: digest[:],
+	})
+}
+
+func (server *Server) downloadChunk(conn *Conn, frame Frame) {
+	req := Messages.ChunkReq{}
+	if err := proto.Unmarshal(frame.Body, &req); err != nil {
+		conn.Send("downloadErr", server.responseID(frame), &Messages.Error{Message: err.Error()})
+		return
+	}
+	data, ok := server.File(req.FileID)
+	if !ok || req.Offset > uint64(len(data)) {
+		conn.Send("downloadErr", server.responseID(frame), &Messages.Error{Code: codeFileNotFound})
+		return
+	}
+	end := req.Offset + uint64(req.Length)
+	if end > uint64(len(data)) {
+		end = uint64(len(data))
+	}
+	conn.Send("fileChunk", server.responseID(frame), &Messages.FileChunk{Offset: req.Offset, Data: data[req.Offset:end]})
+}
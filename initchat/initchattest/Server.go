@@ -0,0 +1,481 @@
+/*
+	Package initchattest runs a scriptable InitChat server on a loopback listener, over TLS,
+	WebSocket or plain TCP, so the client can be tested end to end without a real server
+ */
+
+package initchattest
+
+import (
+	"../../Messages"
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+//A frame as it went over the wire
+type Frame struct {
+	TypeID string
+	RequestID uint32
+	Body []byte
+}
+
+//Answers a frame the test scripted, called on the connection's read goroutine
+type Handler func(conn *Conn, frame Frame)
+
+//One client connection to the server
+type Conn struct {
+	conn io.ReadWriteCloser
+	writeMutex sync.Mutex
+}
+
+type Server struct {
+	//Address the server listens on, such as 127.0.0.1:41234
+	Addr string
+	//Address with the scheme of the server's transport, such as tls://127.0.0.1:41234
+	URL string
+	//Client side TLS config that trusts the server's certificate
+	ClientTLS *tls.Config
+	listener net.Listener
+	mutex sync.Mutex
+	//Signalled whenever a frame is handled so Wait can recheck
+	changed chan struct{}
+	conns map[*Conn]bool
+	expected []*Expectation
+	handlers map[string]Handler
+	received []Frame
+	problems []string
+	omitRequestIDs bool
+	//Signs the server's and clients' certificates, unset for plain TCP
+	ca tls.Certificate
+	files map[string]*storedFile
+	uploads map[string]*upload
+	nextFileID int
+}
+
+//Starts a server on a random loopback port with a fresh self-signed certificate
+func NewServer() (*Server, error) {
+	cert, pool, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS(cert, pool))
+	if err != nil {
+		return nil, err
+	}
+	server := newServer(listener, "tls://" + listener.Addr().String())
+	server.ClientTLS = &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	server.ca = cert
+	go server.accept()
+	return server, nil
+}
+
+//Starts a server that speaks frames over plain TCP, like a local development server
+func NewTCPServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := newServer(listener, "tcp://" + listener.Addr().String())
+	go server.accept()
+	return server, nil
+}
+
+func newServer(listener net.Listener, url string) *Server {
+	return &Server{
+		Addr: listener.Addr().String(),
+		URL: url,
+		listener: listener,
+		changed: make(chan struct{}),
+		conns: make(map[*Conn]bool),
+		handlers: make(map[string]Handler),
+		files: make(map[string]*storedFile),
+		uploads: make(map[string]*upload),
+	}
+}
+
+func selfSignedCert() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "localhost"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(24 * time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA: true,
+		DNSNames: []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool, nil
+}
+
+//Stops listening and drops every connection
+func (server *Server) Close() {
+	server.listener.Close()
+	server.DropConnections()
+}
+
+//Closes the open connections but keeps listening, so clients can reconnect
+func (server *Server) DropConnections() {
+	server.mutex.Lock()
+	conns := make([]*Conn, 0, len(server.conns))
+	for conn := range server.conns {
+		conns = append(conns, conn)
+	}
+	server.mutex.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+//Number of clients connected right now
+func (server *Server) Connections() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return len(server.conns)
+}
+
+func (server *Server) accept() {
+	for {
+		netConn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.serve(server.track(netConn))
+	}
+}
+
+//Registers a connection right away, so DropConnections and Close reach it
+//even before its first frame is read
+func (server *Server) track(transport io.ReadWriteCloser) *Conn {
+	conn := &Conn{conn: transport}
+	server.mutex.Lock()
+	server.conns[conn] = true
+	server.mutex.Unlock()
+	return conn
+}
+
+//Handles the frames of one connection until it closes
+func (server *Server) serve(conn *Conn) {
+	defer func() {
+		server.mutex.Lock()
+		delete(server.conns, conn)
+		server.mutex.Unlock()
+		conn.Close()
+	}()
+	reader := bufio.NewReader(conn.conn)
+	for {
+		frame, err := ReadFrame(reader)
+		if err != nil {
+			return
+		}
+		server.handle(conn, frame)
+	}
+}
+
+//Reads one frame: a big endian header length, the Header and its body
+func ReadFrame(reader io.Reader) (Frame, error) {
+	preHeader := make([]byte, 2)
+	if _, err := io.ReadFull(reader, preHeader); err != nil {
+		return Frame{}, err
+	}
+	headerData := make([]byte, binary.BigEndian.Uint16(preHeader))
+	if _, err := io.ReadFull(reader, headerData); err != nil {
+		return Frame{}, err
+	}
+	header := Messages.Header{}
+	if err := proto.Unmarshal(headerData, &header); err != nil {
+		return Frame{}, err
+	}
+	if header.Length < 0 {
+		return Frame{}, errors.New("negative body length")
+	}
+	body := make([]byte, header.Length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return Frame{}, err
+	}
+	return Frame{TypeID: header.Id, RequestID: header.RequestID, Body: body}, nil
+}
+
+//Writes one frame the way the client's runSend does
+func WriteFrame(writer io.Writer, frame Frame) error {
+	headerData, err := proto.Marshal(&Messages.Header{Id: frame.TypeID, Length: int32(len(frame.Body)), RequestID: frame.RequestID})
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 2, 2 + len(headerData) + len(frame.Body))
+	binary.BigEndian.PutUint16(data, uint16(len(headerData)))
+	data = append(append(data, headerData...), frame.Body...)
+	_, err = writer.Write(data)
+	return err
+}
+
+//Sends a frame to the client, body may be nil for frames without one
+func (conn *Conn) Send(typeID string, requestID uint32, body proto.Message) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = proto.Marshal(body); err != nil {
+			return err
+		}
+	}
+	return conn.SendRaw(typeID, requestID, data)
+}
+
+//Sends a frame whose body is data as is, for bodies that don't parse
+func (conn *Conn) SendRaw(typeID string, requestID uint32, data []byte) error {
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+	return WriteFrame(conn.conn, Frame{TypeID: typeID, RequestID: requestID, Body: data})
+}
+
+func (conn *Conn) Close() {
+	conn.conn.Close()
+}
+
+//Leaves the request ID out of responses from now on, like servers that predate request IDs
+func (server *Server) OmitRequestIDs() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.omitRequestIDs = true
+}
+
+//Request ID a response to frame carries
+func (server *Server) responseID(frame Frame) uint32 {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.omitRequestIDs {
+		return 0
+	}
+	return frame.RequestID
+}
+
+//Runs the next expectation if it is for this frame's type, otherwise the frame type's handler
+func (server *Server) handle(conn *Conn, frame Frame) {
+	var actions []Handler
+	server.mutex.Lock()
+	server.received = append(server.received, frame)
+	if len(server.expected) > 0 && server.expected[0].typeID == frame.TypeID {
+		exp := server.expected[0]
+		server.expected = server.expected[1:]
+		if problem := exp.check(frame); problem != "" {
+			server.problems = append(server.problems, problem)
+		}
+		actions = append(actions, exp.actions...)
+	} else if handler, ok := server.handlers[frame.TypeID]; ok {
+		actions = append(actions, handler)
+	} else {
+		server.problems = append(server.problems, "unexpected " + frame.TypeID + " frame" + server.expecting())
+	}
+	close(server.changed)
+	server.changed = make(chan struct{})
+	server.mutex.Unlock()
+	for _, action := range actions {
+		action(conn, frame)
+	}
+}
+
+//What the server waits for next, for error messages, the caller must hold the mutex
+func (server *Server) expecting() string {
+	if len(server.expected) == 0 {
+		return ", nothing more was expected"
+	}
+	return ", expected " + server.expected[0].typeID
+}
+
+//Answers every frame of the type with handler whenever no expectation is waiting for it
+func (server *Server) Handle(typeID string, handler Handler) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.handlers[typeID] = handler
+}
+
+//Handler answering the frame type, nil if there is none, for wrapping the ones ServeFiles installs
+func (server *Server) Handler(typeID string) Handler {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.handlers[typeID]
+}
+
+//Sends a frame with no request ID to every connected client, as the server does for group messages
+func (server *Server) Push(typeID string, body proto.Message) error {
+	server.mutex.Lock()
+	conns := make([]*Conn, 0, len(server.conns))
+	for conn := range server.conns {
+		conns = append(conns, conn)
+	}
+	server.mutex.Unlock()
+	if len(conns) == 0 {
+		return errors.New("no client is connected")
+	}
+	for _, conn := range conns {
+		if err := conn.Send(typeID, 0, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Every frame received so far
+func (server *Server) Received() []Frame {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]Frame(nil), server.received...)
+}
+
+//Waits until every expectation was met, for requests the client sends without waiting for an answer
+func (server *Server) Wait(timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		server.mutex.Lock()
+		remaining := len(server.expected)
+		changed := server.changed
+		server.mutex.Unlock()
+		if remaining == 0 {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return server.Verify()
+		}
+	}
+}
+
+//Reports expectations that weren't met, frames nobody expected and bodies that didn't match
+func (server *Server) Verify() error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	problems := append([]string(nil), server.problems...)
+	for _, exp := range server.expected {
+		problems = append(problems, "expected " + exp.typeID + " frame was never received")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+//A request the server waits for and how it answers
+type Expectation struct {
+	server *Server
+	typeID string
+	body proto.Message
+	noRequestID bool
+	actions []Handler
+}
+
+//Adds a request the server expects next, in the order Expect was called
+func (server *Server) Expect(typeID string) *Expectation {
+	exp := &Expectation{server: server, typeID: typeID}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.expected = append(server.expected, exp)
+	return exp
+}
+
+//Requires the request body to equal body
+func (exp *Expectation) WithBody(body proto.Message) *Expectation {
+	exp.server.mutex.Lock()
+	defer exp.server.mutex.Unlock()
+	exp.body = body
+	return exp
+}
+
+//Requires a request sent without waiting for an answer, which carries no request ID
+func (exp *Expectation) WithoutRequestID() *Expectation {
+	exp.server.mutex.Lock()
+	defer exp.server.mutex.Unlock()
+	exp.noRequestID = true
+	return exp
+}
+
+//Runs handler when the request arrives, after any actions added before it
+func (exp *Expectation) Do(handler Handler) *Expectation {
+	exp.server.mutex.Lock()
+	defer exp.server.mutex.Unlock()
+	exp.actions = append(exp.actions, handler)
+	return exp
+}
+
+//Answers with a typeID frame carrying body and the request's ID
+func (exp *Expectation) Respond(typeID string, body proto.Message) *Expectation {
+	server := exp.server
+	return exp.Do(func(conn *Conn, frame Frame) {
+		conn.Send(typeID, server.responseID(frame), body)
+	})
+}
+
+//Answers with a typeID frame carrying data as its body, such as a malformed response
+func (exp *Expectation) RespondRaw(typeID string, data []byte) *Expectation {
+	server := exp.server
+	return exp.Do(func(conn *Conn, frame Frame) {
+		conn.SendRaw(typeID, server.responseID(frame), data)
+	})
+}
+
+//Answers with an error frame such as loginErr
+func (exp *Expectation) RespondError(typeID string, code int32, message string) *Expectation {
+	return exp.Respond(typeID, &Messages.Error{Code: code, Message: message})
+}
+
+//Sends a frame with no request ID, such as a group message
+func (exp *Expectation) Push(typeID string, body proto.Message) *Expectation {
+	return exp.Do(func(conn *Conn, frame Frame) {
+		conn.Send(typeID, 0, body)
+	})
+}
+
+//Drops the connection the request came in on
+func (exp *Expectation) Disconnect() *Expectation {
+	return exp.Do(func(conn *Conn, frame Frame) {
+		conn.Close()
+	})
+}
+
+//Describes how frame differs from the expectation, empty when it matches
+func (exp *Expectation) check(frame Frame) string {
+	if exp.noRequestID && frame.RequestID != 0 {
+		return fmt.Sprintf("%s frame has request ID %d, expected none", frame.TypeID, frame.RequestID)
+	}
+	if exp.body == nil {
+		return ""
+	}
+	got := reflect.New(reflect.TypeOf(exp.body).Elem()).Interface().(proto.Message)
+	if err := proto.Unmarshal(frame.Body, got); err != nil {
+		return frame.TypeID + " body could not be parsed: " + err.Error()
+	}
+	if !proto.Equal(got, exp.body) {
+		return frame.TypeID + " body was {" + proto.CompactTextString(got) + "}, expected {" + proto.CompactTextString(exp.body) + "}"
+	}
+	return ""
+}
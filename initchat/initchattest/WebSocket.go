@@ -0,0 +1,86 @@
+/*
+	Serves the scripted server behind an HTTPS endpoint that upgrades to a WebSocket,
+	one binary message per frame like a WebSocket gateway in front of the real server
+ */
+
+package initchattest
+
+import (
+	"crypto/tls"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"time"
+)
+
+//Path the WebSocket endpoint is served on
+const WebSocketPath = "/initchat"
+
+//Starts a server reached at wss://127.0.0.1:port/initchat with a fresh self-signed certificate
+func NewWebSocketServer() (*Server, error) {
+	cert, pool, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS(cert, pool))
+	if err != nil {
+		return nil, err
+	}
+	server := newServer(listener, "wss://" + listener.Addr().String() + WebSocketPath)
+	server.ClientTLS = &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	server.ca = cert
+	upgrader := websocket.Upgrader{}
+	mux := http.NewServeMux()
+	mux.HandleFunc(WebSocketPath, func(writer http.ResponseWriter, req *http.Request) {
+		wsConn, err := upgrader.Upgrade(writer, req, nil)
+		if err != nil {
+			return
+		}
+		server.serve(server.track(&wsStream{conn: wsConn}))
+	})
+	go http.Serve(listener, mux)
+	return server, nil
+}
+
+//Reads the binary messages of a WebSocket as one stream and writes each frame as a message
+type wsStream struct {
+	conn *websocket.Conn
+	reader io.Reader
+}
+
+func (stream *wsStream) Read(data []byte) (int, error) {
+	for {
+		if stream.reader == nil {
+			messageType, reader, err := stream.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			stream.reader = reader
+		}
+		n, err := stream.reader.Read(data)
+		if err == io.EOF {
+			stream.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (stream *wsStream) Write(data []byte) (int, error) {
+	if err := stream.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (stream *wsStream) Close() error {
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+	stream.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+	return stream.conn.Close()
+}